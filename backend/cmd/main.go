@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/lojes7/inquire/internal/router"
+	"github.com/lojes7/inquire/internal/service"
 	"github.com/lojes7/inquire/pkg/infra"
 )
 
@@ -17,7 +18,18 @@ func main() {
 	infra.GetDB().AutoMigrate(&model.Conversation{})
 	infra.GetDB().AutoMigrate(&model.MessageUser{})
 	infra.GetDB().AutoMigrate(&model.ConversationUser{})
-	infra.GetDB().AutoMigrate(&model.File{})*/
+	infra.GetDB().AutoMigrate(&model.File{})
+	infra.GetDB().AutoMigrate(&model.SyncCursor{})
+	infra.GetDB().AutoMigrate(&model.UploadSession{})
+	infra.GetDB().AutoMigrate(&model.UploadChunk{})
+	infra.GetDB().AutoMigrate(&model.Blob{})
+	infra.GetDB().AutoMigrate(&model.MessageRevision{})
+	infra.GetDB().AutoMigrate(&model.ChatRecordItem{})
+	infra.GetDB().AutoMigrate(&model.MessageReaction{})*/
+	go service.CleanExpiredUploads()
+	go service.CollectBlobs()
+	go service.ScanPendingFiles()
+
 	r := router.Launch()
 
 	address := ":" + os.Getenv("PORT")
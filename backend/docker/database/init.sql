@@ -33,10 +33,73 @@ CREATE EXTENSION IF NOT EXISTS vector WITH SCHEMA public;
 COMMENT ON EXTENSION vector IS 'vector data type and ivfflat and hnsw access methods';
 
 
+--
+-- Name: pg_trgm; Type: EXTENSION; Schema: -; Owner: -
+--
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm WITH SCHEMA public;
+
+
+--
+-- Name: EXTENSION pg_trgm; Type: COMMENT; Schema: -; Owner: -
+--
+
+COMMENT ON EXTENSION pg_trgm IS 'text similarity measurement and index searching based on trigrams';
+
+
 SET default_tablespace = '';
 
 SET default_table_access_method = heap;
 
+--
+-- Name: blobs; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.blobs (
+    id bigint NOT NULL,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    hash character(64) NOT NULL,
+    size bigint NOT NULL,
+    content_type character varying(50) NOT NULL,
+    storage_key character varying(255) NOT NULL,
+    ref_count bigint DEFAULT 0 NOT NULL,
+    media_status smallint DEFAULT 0 NOT NULL,
+    width integer,
+    height integer,
+    page_count integer,
+    duration_ms bigint,
+    has_thumbnail boolean DEFAULT false NOT NULL,
+    scan_attempts bigint DEFAULT 0 NOT NULL
+);
+
+
+--
+-- Name: chat_record_items; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.chat_record_items (
+    id bigint NOT NULL,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    record_id bigint NOT NULL,
+    source_message_id bigint NOT NULL,
+    sender_id bigint NOT NULL,
+    sender_name character varying(64) DEFAULT ''::character varying NOT NULL,
+    sent_at timestamp with time zone NOT NULL,
+    status smallint NOT NULL,
+    text character varying(1024) DEFAULT ''::character varying NOT NULL,
+    file_name character varying(255) DEFAULT ''::character varying NOT NULL,
+    file_ext character varying(32) DEFAULT ''::character varying NOT NULL,
+    file_type character varying(50) DEFAULT ''::character varying NOT NULL,
+    file_url character varying(255) DEFAULT ''::character varying NOT NULL,
+    file_size bigint DEFAULT 0 NOT NULL,
+    blob_id bigint DEFAULT 0
+);
+
+
 --
 -- Name: conversation_friends; Type: TABLE; Schema: public; Owner: -
 --
@@ -66,7 +129,13 @@ CREATE TABLE public.conversation_users (
     unread_count bigint DEFAULT 0,
     is_pinned boolean DEFAULT false,
     remark text,
-    last_message_id bigint
+    last_message_id bigint,
+    role smallint DEFAULT 0,
+    last_read_message_id bigint DEFAULT 0,
+    read_at timestamp with time zone,
+    is_muted boolean DEFAULT false,
+    muted_until timestamp with time zone,
+    is_archived boolean DEFAULT false
 );
 
 
@@ -79,7 +148,9 @@ CREATE TABLE public.conversations (
     created_at timestamp without time zone,
     updated_at timestamp without time zone,
     deleted_at timestamp without time zone,
-    type smallint
+    type smallint,
+    name character varying(64),
+    owner_id bigint
 );
 
 
@@ -93,12 +164,17 @@ CREATE TABLE public.files (
     updated_at timestamp with time zone,
     deleted_at timestamp with time zone,
     file_name character varying(255) NOT NULL,
+    file_ext character varying(32) DEFAULT ''::character varying NOT NULL,
     file_type character varying(50) NOT NULL,
     file_url character varying(255) NOT NULL,
     file_size bigint NOT NULL,
     file_content text,
     content_vector public.vector(1536),
-    message_id bigint NOT NULL
+    message_id bigint NOT NULL,
+    blob_id bigint DEFAULT 0,
+    scan_status smallint DEFAULT 0 NOT NULL,
+    scan_signature character varying(255) DEFAULT ''::character varying NOT NULL,
+    file_name_tsv tsvector GENERATED ALWAYS AS (to_tsvector('simple'::regconfig, (file_name)::text)) STORED
 );
 
 
@@ -192,6 +268,35 @@ CREATE SEQUENCE public.friendships_id_seq
 ALTER SEQUENCE public.friendships_id_seq OWNED BY public.friendships.id;
 
 
+--
+-- Name: message_reactions; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.message_reactions (
+    id bigint NOT NULL,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    message_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    emoji character varying(32) NOT NULL
+);
+
+
+--
+-- Name: message_revisions; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.message_revisions (
+    id bigint NOT NULL,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    message_id bigint NOT NULL,
+    text character varying(1024) NOT NULL
+);
+
+
 --
 -- Name: message_users; Type: TABLE; Schema: public; Owner: -
 --
@@ -234,6 +339,8 @@ CREATE TABLE public.messages (
     sender_id bigint,
     conversation_id bigint,
     status smallint DEFAULT 0,
+    reply_to_message_id bigint DEFAULT 0,
+    edited_at timestamp with time zone,
     id bigint NOT NULL,
     created_at timestamp with time zone,
     updated_at timestamp with time zone,
@@ -260,13 +367,68 @@ CREATE SEQUENCE public.messages_id_seq
 ALTER SEQUENCE public.messages_id_seq OWNED BY public.messages.id;
 
 
+--
+-- Name: sync_cursors; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.sync_cursors (
+    id bigint NOT NULL,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    user_id bigint NOT NULL,
+    device_id character varying(64) NOT NULL,
+    cursor bigint NOT NULL
+);
+
+
 --
 -- Name: texts; Type: TABLE; Schema: public; Owner: -
 --
 
 CREATE TABLE public.texts (
     text character varying(1024) CONSTRAINT texts_content_not_null NOT NULL,
-    message_id bigint NOT NULL
+    message_id bigint NOT NULL,
+    text_tsv tsvector GENERATED ALWAYS AS (to_tsvector('simple'::regconfig, (text)::text)) STORED
+);
+
+
+--
+-- Name: upload_chunks; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.upload_chunks (
+    id bigint NOT NULL,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    upload_id bigint NOT NULL,
+    chunk_index bigint NOT NULL,
+    size bigint NOT NULL,
+    checksum character(64) NOT NULL
+);
+
+
+--
+-- Name: upload_sessions; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.upload_sessions (
+    id bigint NOT NULL,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    user_id bigint NOT NULL,
+    conversation_id bigint NOT NULL,
+    file_name character varying(255) NOT NULL,
+    file_size bigint NOT NULL,
+    chunk_size bigint NOT NULL,
+    chunk_count bigint NOT NULL,
+    checksum character(64) NOT NULL,
+    status smallint DEFAULT 0,
+    expires_at timestamp with time zone NOT NULL,
+    message_id bigint DEFAULT 0,
+    reply_to_message_id bigint DEFAULT 0
 );
 
 
@@ -285,10 +447,27 @@ CREATE TABLE public.users (
     gender character varying(12),
     deleted_at timestamp with time zone,
     created_at timestamp with time zone NOT NULL,
+    last_seen_at timestamp with time zone,
     CONSTRAINT chk_users_gender CHECK (((gender)::text = ANY ((ARRAY['male'::character varying, 'female'::character varying, ''::character varying])::text[])))
 );
 
 
+--
+-- Name: blobs blobs_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.blobs
+    ADD CONSTRAINT blobs_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: chat_record_items chat_record_items_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.chat_record_items
+    ADD CONSTRAINT chat_record_items_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: conversation_friends conversation_friends_pkey; Type: CONSTRAINT; Schema: public; Owner: -
 --
@@ -337,6 +516,22 @@ ALTER TABLE ONLY public.friendships
     ADD CONSTRAINT friendships_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: message_reactions message_reactions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.message_reactions
+    ADD CONSTRAINT message_reactions_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: message_revisions message_revisions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.message_revisions
+    ADD CONSTRAINT message_revisions_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: message_users message_users_pkey; Type: CONSTRAINT; Schema: public; Owner: -
 --
@@ -353,6 +548,30 @@ ALTER TABLE ONLY public.messages
     ADD CONSTRAINT messages_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: sync_cursors sync_cursors_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.sync_cursors
+    ADD CONSTRAINT sync_cursors_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: upload_chunks upload_chunks_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.upload_chunks
+    ADD CONSTRAINT upload_chunks_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: upload_sessions upload_sessions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.upload_sessions
+    ADD CONSTRAINT upload_sessions_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: users users_pkey; Type: CONSTRAINT; Schema: public; Owner: -
 --
@@ -361,6 +580,41 @@ ALTER TABLE ONLY public.users
     ADD CONSTRAINT users_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: idx_blobs_deleted_at; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_blobs_deleted_at ON public.blobs USING btree (deleted_at);
+
+
+--
+-- Name: idx_blobs_hash; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE UNIQUE INDEX idx_blobs_hash ON public.blobs USING btree (hash);
+
+
+--
+-- Name: idx_blobs_ref_count; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_blobs_ref_count ON public.blobs USING btree (ref_count);
+
+
+--
+-- Name: idx_chat_record_items_deleted_at; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_chat_record_items_deleted_at ON public.chat_record_items USING btree (deleted_at);
+
+
+--
+-- Name: idx_chat_record_items_record_id; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_chat_record_items_record_id ON public.chat_record_items USING btree (record_id);
+
+
 --
 -- Name: conversation_friends_user_id_friend_id_idx; Type: INDEX; Schema: public; Owner: -
 --
@@ -369,10 +623,10 @@ CREATE UNIQUE INDEX conversation_friends_user_id_friend_id_idx ON public.convers
 
 
 --
--- Name: conversation_users_conversation_id_idx; Type: INDEX; Schema: public; Owner: -
+-- Name: idx_conversation_users_conversation_id; Type: INDEX; Schema: public; Owner: -
 --
 
-CREATE UNIQUE INDEX conversation_users_conversation_id_idx ON public.conversation_users USING btree (conversation_id);
+CREATE INDEX idx_conversation_users_conversation_id ON public.conversation_users USING btree (conversation_id);
 
 
 --
@@ -389,6 +643,13 @@ CREATE UNIQUE INDEX idx_conv_user ON public.conversation_users USING btree (user
 CREATE INDEX idx_conversation_type ON public.conversations USING btree (type);
 
 
+--
+-- Name: idx_conversations_owner_id; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_conversations_owner_id ON public.conversations USING btree (owner_id);
+
+
 --
 -- Name: idx_conversation_users_deleted_at; Type: INDEX; Schema: public; Owner: -
 --
@@ -403,6 +664,34 @@ CREATE INDEX idx_conversation_users_deleted_at ON public.conversation_users USIN
 CREATE UNIQUE INDEX idx_file_msg ON public.files USING btree (message_id);
 
 
+--
+-- Name: idx_files_file_name_trgm; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_files_file_name_trgm ON public.files USING gin (file_name public.gin_trgm_ops);
+
+
+--
+-- Name: idx_files_file_name_tsv; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_files_file_name_tsv ON public.files USING gin (file_name_tsv);
+
+
+--
+-- Name: idx_files_blob_id; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_files_blob_id ON public.files USING btree (blob_id);
+
+
+--
+-- Name: idx_files_content_vector; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_files_content_vector ON public.files USING hnsw (content_vector public.vector_cosine_ops);
+
+
 --
 -- Name: idx_files_deleted_at; Type: INDEX; Schema: public; Owner: -
 --
@@ -410,6 +699,13 @@ CREATE UNIQUE INDEX idx_file_msg ON public.files USING btree (message_id);
 CREATE INDEX idx_files_deleted_at ON public.files USING btree (deleted_at);
 
 
+--
+-- Name: idx_files_scan_status; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_files_scan_status ON public.files USING btree (scan_status);
+
+
 --
 -- Name: idx_friendship_requests_deleted_at; Type: INDEX; Schema: public; Owner: -
 --
@@ -431,6 +727,34 @@ CREATE INDEX idx_friendships_deleted_at ON public.friendships USING btree (delet
 CREATE UNIQUE INDEX idx_friendships_u_f ON public.friendships USING btree (user_id, friend_id, deleted_at);
 
 
+--
+-- Name: idx_message_reaction; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE UNIQUE INDEX idx_message_reaction ON public.message_reactions USING btree (message_id, user_id);
+
+
+--
+-- Name: idx_message_reactions_deleted_at; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_message_reactions_deleted_at ON public.message_reactions USING btree (deleted_at);
+
+
+--
+-- Name: idx_message_revisions_deleted_at; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_message_revisions_deleted_at ON public.message_revisions USING btree (deleted_at);
+
+
+--
+-- Name: idx_message_revisions_message_id; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_message_revisions_message_id ON public.message_revisions USING btree (message_id);
+
+
 --
 -- Name: idx_message_user; Type: INDEX; Schema: public; Owner: -
 --
@@ -452,6 +776,13 @@ CREATE INDEX idx_message_users_deleted_at ON public.message_users USING btree (d
 CREATE INDEX idx_messages_conversation_id ON public.messages USING btree (conversation_id);
 
 
+--
+-- Name: idx_messages_conversation_id_id; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_messages_conversation_id_id ON public.messages USING btree (conversation_id, id);
+
+
 --
 -- Name: idx_messages_deleted_at; Type: INDEX; Schema: public; Owner: -
 --
@@ -459,6 +790,13 @@ CREATE INDEX idx_messages_conversation_id ON public.messages USING btree (conver
 CREATE INDEX idx_messages_deleted_at ON public.messages USING btree (deleted_at);
 
 
+--
+-- Name: idx_messages_edited_at; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_messages_edited_at ON public.messages USING btree (edited_at);
+
+
 --
 -- Name: idx_messages_sender_id; Type: INDEX; Schema: public; Owner: -
 --
@@ -473,6 +811,27 @@ CREATE INDEX idx_messages_sender_id ON public.messages USING btree (sender_id);
 CREATE UNIQUE INDEX idx_requests_sender_receiver ON public.friendship_requests USING btree (sender_id, receiver_id, deleted_at);
 
 
+--
+-- Name: idx_sync_user_device; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE UNIQUE INDEX idx_sync_user_device ON public.sync_cursors USING btree (user_id, device_id);
+
+
+--
+-- Name: idx_texts_text_trgm; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_texts_text_trgm ON public.texts USING gin (text public.gin_trgm_ops);
+
+
+--
+-- Name: idx_texts_text_tsv; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_texts_text_tsv ON public.texts USING gin (text_tsv);
+
+
 --
 -- Name: idx_text_msg; Type: INDEX; Schema: public; Owner: -
 --
@@ -480,6 +839,27 @@ CREATE UNIQUE INDEX idx_requests_sender_receiver ON public.friendship_requests U
 CREATE UNIQUE INDEX idx_text_msg ON public.texts USING btree (message_id);
 
 
+--
+-- Name: idx_upload_chunk; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE UNIQUE INDEX idx_upload_chunk ON public.upload_chunks USING btree (upload_id, chunk_index);
+
+
+--
+-- Name: idx_upload_sessions_expires_at; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_upload_sessions_expires_at ON public.upload_sessions USING btree (expires_at);
+
+
+--
+-- Name: idx_upload_sessions_user_id; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_upload_sessions_user_id ON public.upload_sessions USING btree (user_id);
+
+
 --
 -- Name: idx_users_deleted_at; Type: INDEX; Schema: public; Owner: -
 --
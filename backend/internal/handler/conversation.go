@@ -37,7 +37,13 @@ func ChatHistoryList(c *gin.Context) {
 		return
 	}
 
-	resp, err := service.ChatHistoryList(userID, conversationID)
+	var req model.ChatHistoryReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Fail(c, 400, "分页参数错误")
+		return
+	}
+
+	resp, err := service.ChatHistoryList(userID, conversationID, req)
 	if err != nil {
 		response.Fail(c, 500, err.Error())
 		return
@@ -49,10 +55,114 @@ func ChatHistoryList(c *gin.Context) {
 func ConversationList(c *gin.Context) {
 	userID := c.GetUint64("id")
 
-	resp, err := service.ConversationList(userID)
+	// archived=1 时查看归档的会话
+	resp, err := service.ConversationList(userID, c.Query("archived") == "1")
 	if err != nil {
 		response.Fail(c, 500, err.Error())
 		return
 	}
 	response.Success(c, 200, "success", resp)
 }
+
+// UpdateConversationSettings 修改会话的置顶、免打扰、群备注和归档设置
+func UpdateConversationSettings(c *gin.Context) {
+	userID := c.GetUint64("id")
+	conversationID, err := strconv.ParseUint(c.Param("conversation_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "conversation_id 格式错误")
+		return
+	}
+	var req model.ConversationSettingsReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, 400, "json 解析错误")
+		return
+	}
+
+	resp, err := service.UpdateConversationSettings(userID, conversationID, req)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", resp)
+}
+
+// HideConversation 从会话列表中隐藏会话，有新消息时会重新出现
+func HideConversation(c *gin.Context) {
+	userID := c.GetUint64("id")
+	conversationID, err := strconv.ParseUint(c.Param("conversation_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "conversation_id 格式错误")
+		return
+	}
+
+	err = service.DeleteConversationUser(userID, conversationID)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", nil)
+}
+
+// ClearConversation 清空自己在会话中的聊天记录
+func ClearConversation(c *gin.Context) {
+	userID := c.GetUint64("id")
+	conversationID, err := strconv.ParseUint(c.Param("conversation_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "conversation_id 格式错误")
+		return
+	}
+
+	err = service.ClearConversation(userID, conversationID)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", nil)
+}
+
+// CreateGroupConversation 创建群聊
+func CreateGroupConversation(c *gin.Context) {
+	ownerID := c.GetUint64("id")
+	var req model.CreateGroupReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, 400, "json 解析出错")
+		return
+	}
+
+	memberIDs, err := parseIDList(req.MemberIDs)
+	if err != nil {
+		response.Fail(c, 400, "member_ids参数错误")
+		return
+	}
+
+	conversationID, err := service.CreateGroupConversation(ownerID, req.Name, memberIDs)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 201, "success", model.IDResp{ID: conversationID})
+}
+
+// MarkConversationRead 标记会话已读
+func MarkConversationRead(c *gin.Context) {
+	userID := c.GetUint64("id")
+	id := c.Param("conversation_id")
+	conversationID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "conversation_id参数错误")
+		return
+	}
+
+	var req model.IDReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, 400, "json 解析出错")
+		return
+	}
+
+	err = service.MarkConversationRead(userID, conversationID, req.ID)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", nil)
+}
@@ -72,3 +72,15 @@ func ReviseRemark(c *gin.Context) {
 
 	response.Success(c, 200, "success", nil)
 }
+
+// FriendsPresence 查询所有好友的在线状态
+func FriendsPresence(c *gin.Context) {
+	userID := c.GetUint64("id")
+
+	resp, err := service.FriendsPresence(userID)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", resp)
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/internal/service"
+	"github.com/lojes7/inquire/pkg/response"
+)
+
+// InviteGroupMembers 邀请好友加入群聊
+func InviteGroupMembers(c *gin.Context) {
+	userID := c.GetUint64("id")
+	conversationID, err := strconv.ParseUint(c.Param("conversation_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "conversation_id参数错误")
+		return
+	}
+
+	var req model.GroupMembersReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, 400, "json 解析出错")
+		return
+	}
+	memberIDs, err := parseIDList(req.MemberIDs)
+	if err != nil {
+		response.Fail(c, 400, "member_ids参数错误")
+		return
+	}
+
+	systemMsgID, err := service.InviteGroupMembers(userID, conversationID, memberIDs)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 201, "success", model.IDResp{ID: systemMsgID})
+}
+
+// RemoveGroupMember 将成员移出群聊
+func RemoveGroupMember(c *gin.Context) {
+	userID := c.GetUint64("id")
+	conversationID, err := strconv.ParseUint(c.Param("conversation_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "conversation_id参数错误")
+		return
+	}
+	memberID, err := strconv.ParseUint(c.Param("member_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "member_id参数错误")
+		return
+	}
+
+	systemMsgID, err := service.RemoveGroupMember(userID, conversationID, memberID)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", model.IDResp{ID: systemMsgID})
+}
+
+// LeaveGroup 退出群聊
+func LeaveGroup(c *gin.Context) {
+	userID := c.GetUint64("id")
+	conversationID, err := strconv.ParseUint(c.Param("conversation_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "conversation_id参数错误")
+		return
+	}
+
+	systemMsgID, err := service.LeaveGroup(userID, conversationID)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", model.IDResp{ID: systemMsgID})
+}
+
+// TransferGroupOwner 转让群主
+func TransferGroupOwner(c *gin.Context) {
+	userID := c.GetUint64("id")
+	conversationID, err := strconv.ParseUint(c.Param("conversation_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "conversation_id参数错误")
+		return
+	}
+
+	var req model.IDReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, 400, "json 解析出错")
+		return
+	}
+
+	systemMsgID, err := service.TransferGroupOwner(userID, conversationID, req.ID)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 201, "success", model.IDResp{ID: systemMsgID})
+}
+
+// SetGroupAdmin 设置或取消群管理员
+func SetGroupAdmin(c *gin.Context) {
+	userID := c.GetUint64("id")
+	conversationID, err := strconv.ParseUint(c.Param("conversation_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "conversation_id参数错误")
+		return
+	}
+
+	var req model.GroupAdminReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, 400, "json 解析出错")
+		return
+	}
+
+	systemMsgID, err := service.SetGroupAdmin(userID, conversationID, req.ID, req.IsAdmin)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 201, "success", model.IDResp{ID: systemMsgID})
+}
+
+// parseIDList 把字符串形式的ID列表转换为 uint64
+func parseIDList(ids []string) ([]uint64, error) {
+	result := make([]uint64, 0, len(ids))
+	for _, id := range ids {
+		n, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
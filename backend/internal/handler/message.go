@@ -1,21 +1,20 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"mime"
+	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
+	"path"
 	"strconv"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lojes7/inquire/internal/model"
 	"github.com/lojes7/inquire/internal/service"
 	"github.com/lojes7/inquire/pkg/infra"
 	"github.com/lojes7/inquire/pkg/response"
+	"github.com/lojes7/inquire/pkg/storage"
 )
 
 func SendText(c *gin.Context) {
@@ -28,7 +27,7 @@ func SendText(c *gin.Context) {
 	conversationID := req.ConversationID
 	content := req.Content
 
-	msgID, err := service.SendText(senderID, conversationID, content)
+	msgID, err := service.SendText(senderID, conversationID, req.ReplyToMessageID, content)
 	if err != nil {
 		response.Fail(c, 500, err.Error())
 		return
@@ -39,6 +38,20 @@ func SendText(c *gin.Context) {
 func SendFile(c *gin.Context) {
 	userID := c.GetUint64("id")
 
+	// 限制请求体大小，超过上限的文件不必读完就可以拒绝，多留 1MB 给表单的其它部分
+	if maxSize := infra.GetUploadPolicy().MaxFileSize; maxSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+1<<20)
+	}
+	if _, err := c.MultipartForm(); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			response.Fail(c, 413, service.ErrFileTooLarge.Error())
+			return
+		}
+		response.Fail(c, 400, "表单解析出错")
+		return
+	}
+
 	conversationIDStr := c.PostForm("conversation_id")
 	if conversationIDStr == "" {
 		response.Fail(c, 400, "conversation_id 是空的")
@@ -50,15 +63,24 @@ func SendFile(c *gin.Context) {
 		return
 	}
 
+	var replyToID uint64
+	if replyToIDStr := c.PostForm("reply_to_message_id"); replyToIDStr != "" {
+		replyToID, err = strconv.ParseUint(replyToIDStr, 10, 64)
+		if err != nil {
+			response.Fail(c, 400, "reply_to_message_id 格式错误")
+			return
+		}
+	}
+
 	file, err := c.FormFile("file")
 	if err != nil {
 		response.Fail(c, 400, "没有接收到文件")
 		return
 	}
 
-	resp, err := service.SendFile(userID, conversationID, file)
+	resp, err := service.SendFile(userID, conversationID, replyToID, file)
 	if err != nil {
-		response.Fail(c, 500, err.Error())
+		response.Fail(c, uploadErrorCode(err), err.Error())
 		return
 	}
 	response.Success(c, 201, "success", resp)
@@ -73,23 +95,42 @@ func DownloadFile(c *gin.Context) {
 		return
 	}
 
-	fileURL, err := service.DownloadFile(userID, messageID)
+	file, err := service.DownloadFile(userID, messageID)
 	if err != nil {
-		response.Fail(c, 500, err.Error())
+		response.Fail(c, scanErrorCode(err), err.Error())
 		return
 	}
+	serveFile(c, file)
+}
 
-	// 安全检查，确保文件路径在允许的目录下
-	allowedDir := infra.GetFilePath()
-	if !strings.HasPrefix(fileURL, allowedDir) {
-		response.Fail(c, 403, "非法文件路径")
+// DownloadChatRecordFile 下载聊天记录中的文件
+func DownloadChatRecordFile(c *gin.Context) {
+	userID := c.GetUint64("id")
+	messageID, err := strconv.ParseUint(c.Param("message_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "message_id 格式错误")
+		return
+	}
+	itemID, err := strconv.ParseUint(c.Param("item_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "item_id 格式错误")
 		return
 	}
 
-	// 打开文件
-	file, err := os.Open(fileURL)
+	file, err := service.DownloadChatRecordFile(userID, messageID, itemID)
 	if err != nil {
-		if os.IsNotExist(err) {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	serveFile(c, file)
+}
+
+// serveFile 返回文件内容，支持 Range 和条件请求
+func serveFile(c *gin.Context, file *model.File) {
+	// 先确认存储中的文件存在，ServeContent 开始写响应后就无法再返回错误
+	_, err := infra.GetStorage().Stat(c.Request.Context(), file.FileURL)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
 			response.Fail(c, 404, "文件不存在")
 		} else {
 			log.Printf("打开文件失败: %v\n", err)
@@ -97,35 +138,67 @@ func DownloadFile(c *gin.Context) {
 		}
 		return
 	}
-	defer file.Close()
 
-	// 获取文件信息
-	fileInfo, err := file.Stat()
-	if err != nil {
-		response.Fail(c, 500, "无法获取文件信息")
-		return
+	// 下载时使用原文件名，存储中的文件名是内容的 sha256
+	// 早期的记录没有保存扩展名，从存储的文件名中取
+	ext := file.FileExt
+	if ext == "" {
+		ext = path.Ext(file.FileURL)
+	}
+	fileName := file.FileName + ext
+	disposition := "attachment"
+	if c.Query("inline") == "1" {
+		disposition = "inline"
 	}
 
-	// 设置响应头
-	fileName := filepath.Base(fileURL) // 例如 "report.pdf"
+	// 文件内容不会改变，消息ID和大小即可唯一确定
+	c.Header("Content-Type", file.FileType)
+	c.Header("ETag", fmt.Sprintf(`"%d-%d"`, file.MessageID, file.FileSize))
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Header("Content-Disposition", fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`,
+		disposition, url.PathEscape(fileName), url.PathEscape(fileName)))
 
-	// 自动推断 MIME 类型
-	mimeType := mime.TypeByExtension(filepath.Ext(fileName))
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
+	// ServeContent 处理 Range、If-None-Match、If-Modified-Since 等条件请求
+	// 只会按需读取请求的范围，不加载全文件到内存
+	content := storage.NewReadSeeker(c.Request.Context(), infra.GetStorage(), file.FileURL, file.FileSize)
+	defer content.Close()
+	http.ServeContent(c.Writer, c.Request, fileName, file.CreatedAt, content)
+}
+
+// FileThumbnail 获取图片文件的缩略图
+func FileThumbnail(c *gin.Context) {
+	userID := c.GetUint64("id")
+	messageID, err := strconv.ParseUint(c.Param("message_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "message_id 格式错误")
+		return
 	}
 
-	c.Header("Content-Type", mimeType)
-	c.Header("Content-Length", strconv.FormatInt(fileInfo.Size(), 10))
-	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, url.PathEscape(fileName)))
+	key, modTime, err := service.FileThumbnail(userID, messageID, c.Query("size"))
+	if err != nil {
+		response.Fail(c, scanErrorCode(err), err.Error())
+		return
+	}
 
-	// 流式传输文件（不加载全文件到内存）
-	_, err = io.Copy(c.Writer, file)
+	info, err := infra.GetStorage().Stat(c.Request.Context(), key)
 	if err != nil {
-		// 客户端可能取消了下载，通常不用报错
-		log.Printf("文件传输中断: %v", err)
+		if errors.Is(err, storage.ErrNotFound) {
+			response.Fail(c, 404, "缩略图不存在")
+		} else {
+			log.Printf("打开缩略图失败: %v\n", err)
+			response.Fail(c, 500, "缩略图读取失败")
+		}
 		return
 	}
+
+	// 缩略图由内容决定，内容不变缩略图就不变
+	c.Header("Content-Type", "image/jpeg")
+	c.Header("ETag", fmt.Sprintf(`"%d-%s"`, messageID, path.Base(key)))
+	c.Header("Cache-Control", "private, max-age=604800")
+
+	content := storage.NewReadSeeker(c.Request.Context(), infra.GetStorage(), key, info.Size)
+	defer content.Close()
+	http.ServeContent(c.Writer, c.Request, path.Base(key), modTime, content)
 }
 
 func RecallMessage(c *gin.Context) {
@@ -144,6 +217,158 @@ func RecallMessage(c *gin.Context) {
 	response.Success(c, 201, "success", systemMsgID)
 }
 
+// ForwardMessages 把消息逐条或合并转发到一个或多个会话
+func ForwardMessages(c *gin.Context) {
+	userID := c.GetUint64("id")
+	var req model.ForwardReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, 400, "json 解析错误")
+		return
+	}
+	messageIDs, err := parseIDList(req.MessageIDs)
+	if err != nil {
+		response.Fail(c, 400, "message_ids参数错误")
+		return
+	}
+	conversationIDs, err := parseIDList(req.ConversationIDs)
+	if err != nil {
+		response.Fail(c, 400, "conversation_ids参数错误")
+		return
+	}
+
+	resp, err := service.ForwardMessages(userID, messageIDs, conversationIDs, req.Merged)
+	if err != nil {
+		code := scanErrorCode(err)
+		if code == 500 {
+			code = uploadErrorCode(err)
+		}
+		response.Fail(c, code, err.Error())
+		return
+	}
+	response.Success(c, 201, "success", resp)
+}
+
+// ChatRecord 查看合并转发的聊天记录
+func ChatRecord(c *gin.Context) {
+	userID := c.GetUint64("id")
+	messageID, err := strconv.ParseUint(c.Param("message_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "message_id 格式错误")
+		return
+	}
+
+	resp, err := service.ChatRecord(userID, messageID)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", resp)
+}
+
+// EditMessage 编辑自己发送的文本消息
+func EditMessage(c *gin.Context) {
+	userID := c.GetUint64("id")
+	messageID, err := strconv.ParseUint(c.Param("message_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "message_id 格式错误")
+		return
+	}
+	var req model.EditMessageReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, 400, "json 解析错误")
+		return
+	}
+
+	err = service.EditMessage(userID, messageID, req.Content)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", nil)
+}
+
+// MessageRevisions 查看消息的编辑历史
+func MessageRevisions(c *gin.Context) {
+	userID := c.GetUint64("id")
+	messageID, err := strconv.ParseUint(c.Param("message_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "message_id 格式错误")
+		return
+	}
+
+	resp, err := service.MessageRevisions(userID, messageID)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", resp)
+}
+
+// ToggleReaction 对消息进行或取消表情回应
+func ToggleReaction(c *gin.Context) {
+	userID := c.GetUint64("id")
+	messageID, err := strconv.ParseUint(c.Param("message_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "message_id 格式错误")
+		return
+	}
+	var req model.ReactReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, 400, "json 解析错误")
+		return
+	}
+
+	resp, err := service.ToggleReaction(userID, messageID, req.Emoji)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", resp)
+}
+
+// StarMessage 收藏消息
+func StarMessage(c *gin.Context) {
+	setMessageStarred(c, true)
+}
+
+// UnstarMessage 取消收藏消息
+func UnstarMessage(c *gin.Context) {
+	setMessageStarred(c, false)
+}
+
+func setMessageStarred(c *gin.Context, starred bool) {
+	userID := c.GetUint64("id")
+	messageID, err := strconv.ParseUint(c.Param("message_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "message_id 格式错误")
+		return
+	}
+
+	err = service.StarMessage(userID, messageID, starred)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", nil)
+}
+
+// StarredMessages 查看或搜索收藏的消息
+func StarredMessages(c *gin.Context) {
+	userID := c.GetUint64("id")
+	var req model.StarredMessageReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Fail(c, 400, "查询参数错误")
+		return
+	}
+
+	resp, err := service.StarredMessages(userID, req)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", resp)
+}
+
 func DeleteMessage(c *gin.Context) {
 	userID := c.GetUint64("id")
 	var req model.IDReq
@@ -159,3 +384,47 @@ func DeleteMessage(c *gin.Context) {
 	}
 	response.Success(c, 200, "success", nil)
 }
+
+// SearchMessages 搜索消息
+func SearchMessages(c *gin.Context) {
+	userID := c.GetUint64("id")
+	var req model.SearchMessageReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Fail(c, 400, "搜索参数错误")
+		return
+	}
+
+	resp, err := service.SearchMessages(userID, req)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", resp)
+}
+
+func SearchFiles(c *gin.Context) {
+	userID := c.GetUint64("id")
+	var req model.SearchFileReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Fail(c, 400, "搜索参数错误")
+		return
+	}
+
+	resp, err := service.SearchFiles(userID, req)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", resp)
+}
+
+// scanErrorCode 文件还在等待安全扫描时返回 409，未通过扫描时返回 403，其余返回 500
+func scanErrorCode(err error) int {
+	switch {
+	case errors.Is(err, service.ErrFileScanPending):
+		return 409
+	case errors.Is(err, service.ErrFileInfected), errors.Is(err, service.ErrFileScanFailed):
+		return 403
+	}
+	return 500
+}
@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/internal/service"
+	"github.com/lojes7/inquire/pkg/response"
+)
+
+// Sync 离线同步
+func Sync(c *gin.Context) {
+	userID := c.GetUint64("id")
+	var req model.SyncReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		response.Fail(c, 400, "同步参数错误")
+		return
+	}
+
+	resp, err := service.Sync(userID, req)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", resp)
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/internal/service"
+	"github.com/lojes7/inquire/pkg/response"
+)
+
+// CreateUpload 创建分片上传会话
+func CreateUpload(c *gin.Context) {
+	userID := c.GetUint64("id")
+	var req model.CreateUploadReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Fail(c, 400, "请求参数错误")
+		return
+	}
+
+	resp, err := service.CreateUpload(userID, req)
+	if err != nil {
+		response.Fail(c, uploadErrorCode(err), err.Error())
+		return
+	}
+	response.Success(c, 201, "success", resp)
+}
+
+// UploadChunk 上传一个分片，请求体为分片的原始内容
+func UploadChunk(c *gin.Context) {
+	userID := c.GetUint64("id")
+	uploadID, err := strconv.ParseUint(c.Param("upload_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "upload_id 格式错误")
+		return
+	}
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		response.Fail(c, 400, "分片序号格式错误")
+		return
+	}
+	if c.Request.ContentLength < 0 {
+		response.Fail(c, 411, "缺少 Content-Length")
+		return
+	}
+
+	resp, err := service.UploadChunk(userID, uploadID, index,
+		c.Request.Body, c.Request.ContentLength, c.GetHeader("X-Chunk-Checksum"))
+	if err != nil {
+		response.Fail(c, uploadErrorCode(err), err.Error())
+		return
+	}
+	response.Success(c, 200, "success", resp)
+}
+
+// UploadStatus 查询上传会话的状态
+func UploadStatus(c *gin.Context) {
+	userID := c.GetUint64("id")
+	uploadID, err := strconv.ParseUint(c.Param("upload_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "upload_id 格式错误")
+		return
+	}
+
+	resp, err := service.UploadStatus(userID, uploadID)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", resp)
+}
+
+// CompleteUpload 合并分片并发送文件消息
+func CompleteUpload(c *gin.Context) {
+	userID := c.GetUint64("id")
+	uploadID, err := strconv.ParseUint(c.Param("upload_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "upload_id 格式错误")
+		return
+	}
+
+	resp, err := service.CompleteUpload(userID, uploadID)
+	if err != nil {
+		response.Fail(c, uploadErrorCode(err), err.Error())
+		return
+	}
+	response.Success(c, 201, "success", resp)
+}
+
+// AbortUpload 取消上传
+func AbortUpload(c *gin.Context) {
+	userID := c.GetUint64("id")
+	uploadID, err := strconv.ParseUint(c.Param("upload_id"), 10, 64)
+	if err != nil {
+		response.Fail(c, 400, "upload_id 格式错误")
+		return
+	}
+
+	err = service.AbortUpload(userID, uploadID)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", nil)
+}
+
+// StorageUsage 查询自己的存储用量和配额
+func StorageUsage(c *gin.Context) {
+	userID := c.GetUint64("id")
+
+	resp, err := service.StorageUsage(userID)
+	if err != nil {
+		response.Fail(c, 500, err.Error())
+		return
+	}
+	response.Success(c, 200, "success", resp)
+}
+
+// uploadErrorCode 上传限制导致的错误返回 413 或 415，其余返回 500
+func uploadErrorCode(err error) int {
+	switch {
+	case errors.Is(err, service.ErrFileTooLarge),
+		errors.Is(err, service.ErrUserQuotaExceeded),
+		errors.Is(err, service.ErrConversationQuotaExceeded):
+		return 413
+	case errors.Is(err, service.ErrFileTypeNotAllowed):
+		return 415
+	}
+	return 500
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/internal/service"
+	"github.com/lojes7/inquire/internal/ws"
+)
+
+// RegisterWsCommands 注册客户端可以通过 websocket 发送的命令
+func RegisterWsCommands() {
+	ws.HandleCommand("ping", WsPing)
+	ws.HandleCommand("send_text", WsSendText)
+	ws.HandleCommand("read", WsMarkRead)
+	ws.HandleCommand("typing", WsTyping)
+}
+
+// bindWsData 解析并校验命令的 data 字段，校验规则与 http 请求体相同
+func bindWsData(data json.RawMessage, obj any) error {
+	if err := json.Unmarshal(data, obj); err != nil {
+		return errors.New("json 解析出错")
+	}
+	if err := binding.Validator.ValidateStruct(obj); err != nil {
+		return errors.New("参数不合法")
+	}
+	return nil
+}
+
+// WsPing 应用层心跳，返回服务器时间
+func WsPing(client *ws.Client, data json.RawMessage) (any, error) {
+	return map[string]any{
+		"server_time": time.Now(),
+	}, nil
+}
+
+// WsSendText 通过 websocket 发送文本消息
+func WsSendText(client *ws.Client, data json.RawMessage) (any, error) {
+	var req model.SendTextReq
+	if err := bindWsData(data, &req); err != nil {
+		return nil, err
+	}
+
+	msgID, err := service.SendText(client.UserID, req.ConversationID, req.ReplyToMessageID, req.Content)
+	if err != nil {
+		return nil, err
+	}
+	return model.IDResp{ID: msgID}, nil
+}
+
+// WsMarkRead 通过 websocket 标记会话已读
+func WsMarkRead(client *ws.Client, data json.RawMessage) (any, error) {
+	var req model.ReadReq
+	if err := bindWsData(data, &req); err != nil {
+		return nil, err
+	}
+
+	err := service.MarkConversationRead(client.UserID, req.ConversationID, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// WsTyping 通过 websocket 发送正在输入状态
+func WsTyping(client *ws.Client, data json.RawMessage) (any, error) {
+	var req model.TypingReq
+	if err := bindWsData(data, &req); err != nil {
+		return nil, err
+	}
+
+	err := service.SendTyping(client.UserID, req.ConversationID, req.Typing)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"github.com/lojes7/inquire/pkg/utils"
+	"gorm.io/gorm"
+)
+
+// Blob 按内容寻址的文件内容，相同内容只保存一份
+// 每条引用它的文件消息计数一次，计数归零一段时间后由垃圾回收删除
+type Blob struct {
+	MyModel
+	// 内容的 sha256，十六进制小写
+	Hash        string `gorm:"type:char(64);not null;uniqueIndex"`
+	Size        int64  `gorm:"not null"`
+	ContentType string `gorm:"type:varchar(50);not null"`
+	StorageKey  string `gorm:"type:varchar(255);not null"`
+	RefCount    int    `gorm:"not null;default:0;index"`
+
+	// 媒体信息，在第一次发送该内容时生成，不能确定的为 NULL
+	MediaStatus  uint8  `gorm:"type:smallint;not null;default:0"`
+	Width        *int   `gorm:"type:integer"`
+	Height       *int   `gorm:"type:integer"`
+	PageCount    *int   `gorm:"type:integer"`
+	DurationMs   *int64 `gorm:"type:bigint"`
+	HasThumbnail bool   `gorm:"not null;default:false"`
+
+	// 安全扫描连续失败的次数，达到上限后不再重试
+	ScanAttempts int `gorm:"not null;default:0"`
+}
+
+// Blob 媒体信息的处理状态
+const (
+	MEDIA_PENDING uint8 = iota
+	MEDIA_DONE
+	MEDIA_FAILED
+)
+
+func (b *Blob) BeforeCreate(db *gorm.DB) error {
+	if b.ID == 0 {
+		b.ID = utils.NewUniqueID()
+	}
+	return nil
+}
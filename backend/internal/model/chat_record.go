@@ -0,0 +1,38 @@
+package model
+
+import (
+	"time"
+
+	"github.com/lojes7/inquire/pkg/utils"
+	"gorm.io/gorm"
+)
+
+// ChatRecordItem 合并转发的聊天记录中的一条消息
+// 保存转发时的内容快照，原消息之后被撤回或编辑不影响聊天记录
+type ChatRecordItem struct {
+	MyModel
+	// 聊天记录消息的ID
+	RecordID        uint64    `gorm:"type:bigint;not null;index"`
+	SourceMessageID uint64    `gorm:"type:bigint;not null"`
+	SenderID        uint64    `gorm:"type:bigint;not null"`
+	SenderName      string    `gorm:"type:varchar(64);not null;default:''"`
+	SentAt          time.Time `gorm:"not null"`
+	// TEXT 或 FILE
+	Status uint8  `gorm:"type:smallint;not null"`
+	Text   string `gorm:"type:varchar(1024);not null;default:''"`
+
+	// 以下字段仅对文件有效，含义与 File 相同
+	FileName string `gorm:"type:varchar(255);not null;default:''"`
+	FileExt  string `gorm:"type:varchar(32);not null;default:''"`
+	FileType string `gorm:"type:varchar(50);not null;default:''"`
+	FileURL  string `gorm:"type:varchar(255);not null;default:''"`
+	FileSize int64  `gorm:"not null;default:0"`
+	BlobID   uint64 `gorm:"type:bigint;default:0"`
+}
+
+func (i *ChatRecordItem) BeforeCreate(db *gorm.DB) error {
+	if i.ID == 0 {
+		i.ID = utils.NewUniqueID()
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/lojes7/inquire/pkg/utils"
 	"gorm.io/gorm"
@@ -14,6 +15,8 @@ const (
 	RECALLED
 	SYSTEM
 	FILE
+	// 合并转发的聊天记录，标题保存在 texts 中，内容保存在 chat_record_items 中
+	CHAT_RECORD
 )
 
 const (
@@ -21,13 +24,51 @@ const (
 	GROUP
 )
 
+// 群成员角色
+const (
+	MEMBER uint8 = iota
+	ADMIN
+	OWNER
+)
+
+// 文件安全扫描的状态
+const (
+	SCAN_CLEAN uint8 = iota
+	SCAN_PENDING
+	SCAN_INFECTED
+	// 多次扫描都没有成功完成，文件不能下载
+	SCAN_FAILED
+)
+
 type Message struct {
 	SenderID       uint64 `gorm:"bigint;index"`
 	ConversationID uint64 `gorm:"bigint;index"`
 	Status         uint8  `gorm:"smallint;default:0"`
+	// 回复的消息，必须在同一个会话中，不是回复时为 0
+	ReplyToMessageID uint64 `gorm:"type:bigint;default:0"`
+	// 最后一次编辑的时间，没有编辑过时为 NULL
+	EditedAt *time.Time `gorm:"type:timestamptz;index"`
 	MyModel
 }
 
+// MessageRevision 消息被编辑前的内容，每编辑一次保存一条
+// CreatedAt 即该内容被替换的时间
+type MessageRevision struct {
+	MyModel
+	MessageID uint64 `gorm:"type:bigint;not null;index"`
+	Text      string `gorm:"type:varchar(1024);not null"`
+}
+
+// MessageReaction 用户对消息的表情回应，每个用户对一条消息只保留一个表情
+// 回应不是消息，不会出现在聊天记录中，也不影响会话的最后一条消息和未读数
+// 取消时软删除，离线同步按 updated_at 找出回应有变化的消息
+type MessageReaction struct {
+	MyModel
+	MessageID uint64 `gorm:"type:bigint;not null;uniqueIndex:idx_message_reaction"`
+	UserID    uint64 `gorm:"type:bigint;not null;uniqueIndex:idx_message_reaction"`
+	Emoji     string `gorm:"type:varchar(32);not null"`
+}
+
 type MessageUser struct {
 	MyModel
 	UserID    uint64 `gorm:"bigint;uniqueIndex:idx_message_user"`
@@ -40,6 +81,9 @@ type Conversation struct {
 	// 如果是私聊（type为0）则conversation_id 是friend_id
 	// 如果是群里（type为1）则conversation_id 由雪花ID生成器分配
 	Type uint8 `gorm:"smallint;not null"`
+	// 以下字段仅对群聊有效
+	Name    string `gorm:"type:varchar(64)"`
+	OwnerID uint64 `gorm:"type:bigint;index"`
 }
 type ConversationUser struct {
 	MyModel
@@ -49,12 +93,25 @@ type ConversationUser struct {
 	Remark         string `gorm:"varchar(32)"`
 	LastMessageID  uint64 `gorm:"type:bigint;index"`
 	IsPinned       bool   `gorm:"type:boolean;default:false"`
+	Role           uint8  `gorm:"type:smallint;default:0"`
+	// 用户已读到的最后一条消息，用于已读回执
+	LastReadMessageID uint64 `gorm:"type:bigint;default:0"`
+	// 最后一次标记已读的时间，离线同步据此发现已读位置的变化
+	// 标记已读不修改 updated_at，以免影响会话列表的排序
+	ReadAt *time.Time
+	// 免打扰，MutedUntil 为空时一直免打扰，否则到期后自动解除
+	IsMuted    bool `gorm:"type:boolean;default:false"`
+	MutedUntil *time.Time
+	// 归档的会话不在默认的会话列表中显示
+	IsArchived bool `gorm:"type:boolean;default:false"`
 }
 
 type Text struct {
 	MyModel
 	Text      string `gorm:"varchar(1024);not null"`
 	MessageID uint64 `gorm:"bigint;index"`
+	// 全文搜索用的分词结果，由数据库根据 text 自动生成
+	TextTsv string `gorm:"->;type:tsvector GENERATED ALWAYS AS (to_tsvector('simple', text)) STORED;index:idx_texts_text_tsv,type:gin"`
 }
 
 type Vector []float32
@@ -62,15 +119,28 @@ type Vector []float32
 type File struct {
 	MyModel
 
-	FileName  string `gorm:"type:varchar(255);not null"`
+	FileName string `gorm:"type:varchar(255);not null"`
+	// 原文件的扩展名，包含开头的点，FileName 中不含扩展名
+	FileExt   string `gorm:"type:varchar(32);not null;default:''"`
 	FileType  string `gorm:"type:varchar(50);not null"`
 	FileURL   string `gorm:"type:varchar(255);not null"`
 	FileSize  int64  `gorm:"not null"`
 	MessageID uint64 `gorm:"type:bigint;index"`
+	// 文件内容对应的 Blob，FileURL 为该 Blob 的存储 key，早期的记录为 0
+	BlobID uint64 `gorm:"type:bigint;default:0;index"`
+	// 全文搜索用的分词结果，由数据库根据 file_name 自动生成
+	FileNameTsv string `gorm:"->;type:tsvector GENERATED ALWAYS AS (to_tsvector('simple', file_name)) STORED;index:idx_files_file_name_tsv,type:gin"`
 
+	// 从文件中提取的文本，只支持 txt、csv、md 和 PDF
 	FileContent string `gorm:"type:text"`
 
-	ContentVector Vector `gorm:"type:vector(1536)"`
+	// FileContent 的向量，用于语义搜索，提取失败或不支持的文件为空
+	ContentVector Vector `gorm:"type:vector(1536);index:idx_files_content_vector,type:hnsw,expression:content_vector vector_cosine_ops"`
+
+	// 安全扫描的状态，只有 SCAN_CLEAN 的文件可以下载，早期的记录视为已通过
+	ScanStatus uint8 `gorm:"type:smallint;not null;default:0;index"`
+	// 扫描命中的病毒名称
+	ScanSignature string `gorm:"type:varchar(255);not null;default:''"`
 }
 
 func (m *Message) BeforeCreate(db *gorm.DB) error {
@@ -80,6 +150,20 @@ func (m *Message) BeforeCreate(db *gorm.DB) error {
 	return nil
 }
 
+func (r *MessageRevision) BeforeCreate(db *gorm.DB) error {
+	if r.ID == 0 {
+		r.ID = utils.NewUniqueID()
+	}
+	return nil
+}
+
+func (r *MessageReaction) BeforeCreate(db *gorm.DB) error {
+	if r.ID == 0 {
+		r.ID = utils.NewUniqueID()
+	}
+	return nil
+}
+
 func (m *MessageUser) BeforeCreate(db *gorm.DB) error {
 	if m.ID == 0 {
 		m.ID = utils.NewUniqueID()
@@ -110,8 +194,9 @@ func (f *File) BeforeCreate(db *gorm.DB) error {
 }
 
 func (v Vector) Value() (driver.Value, error) {
+	// pgvector 不接受空向量，没有向量时存为 NULL
 	if len(v) == 0 {
-		return "[]", nil
+		return nil, nil
 	}
 
 	values := make([]string, len(v))
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 // IDReq 消息ID请求体
 type IDReq struct {
 	ID uint64 `json:"id,string" binding:"required,gt=0"`
@@ -52,7 +54,122 @@ type AddFriendReq struct {
 }
 
 // SendTextReq 发送消息请求体
+// ReplyToMessageID 为回复的消息，可选
 type SendTextReq struct {
+	ConversationID   uint64 `json:"conversation_id,string" binding:"required,gt=0"`
+	Content          string `json:"content" binding:"required,max=1024"`
+	ReplyToMessageID uint64 `json:"reply_to_message_id,string"`
+}
+
+// EditMessageReq 编辑消息请求体
+type EditMessageReq struct {
+	Content string `json:"content" binding:"required,max=1024"`
+}
+
+// ReactReq 表情回应请求体
+type ReactReq struct {
+	Emoji string `json:"emoji" binding:"required,max=32"`
+}
+
+// ForwardReq 转发消息请求体
+// Merged 为 true 时把所有消息合并为一条聊天记录，消息必须来自同一个会话
+type ForwardReq struct {
+	MessageIDs      []string `json:"message_ids" binding:"required,min=1,max=100,dive,numeric"`
+	ConversationIDs []string `json:"conversation_ids" binding:"required,min=1,max=9,dive,numeric"`
+	Merged          bool     `json:"merged"`
+}
+
+// CreateGroupReq 创建群聊请求体
+// MemberIDs 为除群主以外的初始成员ID
+type CreateGroupReq struct {
+	Name      string   `json:"name" binding:"required,min=1,max=64"`
+	MemberIDs []string `json:"member_ids" binding:"required,min=2,max=499,dive,numeric"`
+}
+
+// GroupMembersReq 邀请群成员请求体
+type GroupMembersReq struct {
+	MemberIDs []string `json:"member_ids" binding:"required,min=1,max=499,dive,numeric"`
+}
+
+// GroupAdminReq 设置群管理员请求体
+type GroupAdminReq struct {
+	ID      uint64 `json:"id,string" binding:"required,gt=0"`
+	IsAdmin bool   `json:"is_admin"`
+}
+
+// ChatHistoryReq 加载聊天记录的查询参数
+// Before 和 After 都是消息ID，只能二选一，都不传时加载最新的一页
+type ChatHistoryReq struct {
+	Before uint64 `form:"before" binding:"omitempty,gt=0,excluded_with=After"`
+	After  uint64 `form:"after" binding:"omitempty,gt=0"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+}
+
+// SyncReq 离线同步的查询参数
+// 不传 Since 时使用服务端为该设备记录的游标
+type SyncReq struct {
+	Since    uint64 `form:"since"`
+	DeviceID string `form:"device_id" binding:"max=64"`
+	Limit    int    `form:"limit" binding:"omitempty,min=1,max=500"`
+}
+
+// ReadReq 通过 websocket 标记已读的请求体
+type ReadReq struct {
+	ConversationID uint64 `json:"conversation_id,string" binding:"required,gt=0"`
+	ID             uint64 `json:"id,string" binding:"required,gt=0"`
+}
+
+// TypingReq 通过 websocket 发送正在输入状态的请求体
+// Typing 为 false 表示停止输入
+type TypingReq struct {
+	ConversationID uint64 `json:"conversation_id,string" binding:"required,gt=0"`
+	Typing         bool   `json:"typing"`
+}
+
+// SearchMessageReq 搜索消息的查询参数
+// ConversationID 不传时搜索用户所在的全部会话，Before 为上一页返回的游标
+type SearchMessageReq struct {
+	Q              string `form:"q" binding:"required,min=1,max=64"`
+	ConversationID uint64 `form:"conversation_id"`
+	Before         uint64 `form:"before"`
+	Limit          int    `form:"limit" binding:"omitempty,min=1,max=50"`
+}
+
+// ConversationSettingsReq 修改会话设置，只修改传了的字段
+// MutedUntil 传了时开启免打扰到该时间，IsMuted 为 false 时同时清除
+// Remark 只能为群聊设置，空字符串表示清除备注
+type ConversationSettingsReq struct {
+	IsPinned   *bool      `json:"is_pinned"`
+	IsMuted    *bool      `json:"is_muted"`
+	MutedUntil *time.Time `json:"muted_until"`
+	Remark     *string    `json:"remark" binding:"omitempty,max=32"`
+	IsArchived *bool      `json:"is_archived"`
+}
+
+// StarredMessageReq 查看收藏消息的查询参数
+// Q 不传时列出全部收藏，传了时只在收藏中搜索，Before 为上一页返回的游标
+type StarredMessageReq struct {
+	Q              string `form:"q" binding:"omitempty,max=64"`
+	ConversationID uint64 `form:"conversation_id"`
+	Before         uint64 `form:"before"`
+	Limit          int    `form:"limit" binding:"omitempty,min=1,max=50"`
+}
+
+// SearchFileReq 按内容语义搜索文件的查询参数
+type SearchFileReq struct {
+	Q              string `form:"q" binding:"required,min=1,max=256"`
+	ConversationID uint64 `form:"conversation_id"`
+	Limit          int    `form:"limit" binding:"omitempty,min=1,max=50"`
+}
+
+// CreateUploadReq 创建分片上传会话的请求体
+// Checksum 为整个文件的 sha256，ChunkSize 不传时使用服务端默认值
+type CreateUploadReq struct {
 	ConversationID uint64 `json:"conversation_id,string" binding:"required,gt=0"`
-	Content        string `json:"content" binding:"required,max=1024"`
+	FileName       string `json:"file_name" binding:"required,max=255"`
+	FileSize       int64  `json:"file_size" binding:"required,min=1"`
+	Checksum       string `json:"checksum" binding:"required,len=64,hexadecimal"`
+	ChunkSize      int64  `json:"chunk_size" binding:"omitempty,min=262144,max=33554432"`
+	// 合并后发送的文件消息回复的消息，可选
+	ReplyToMessageID uint64 `json:"reply_to_message_id,string"`
 }
@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // IDResp 通用返回体
 // 返回一个uint64的ID
@@ -64,16 +67,160 @@ type ConversationListResp struct {
 	ConversationID uint64 `json:"conversation_id,string"`
 	UnreadCount    int    `json:"unread_count"`
 	Content        string `json:"content"`
+	ConversationSettings
+}
+
+// ConversationSettings 用户对会话的个人设置
+// IsMuted 为当前是否处于免打扰，到期的免打扰返回 false
+type ConversationSettings struct {
+	IsPinned   bool       `json:"is_pinned"`
+	IsMuted    bool       `json:"is_muted"`
+	MutedUntil *time.Time `json:"muted_until"`
+	IsArchived bool       `json:"is_archived"`
+}
+
+// ConversationSettingsResp 修改后的会话设置
+type ConversationSettingsResp struct {
+	ConversationID uint64 `json:"conversation_id,string"`
+	Remark         string `json:"remark"`
+	ConversationSettings
 }
 
 // ChatHistoryResp 聊天记录返回体
+// Content 由数据库直接生成 json：文本消息为字符串，文件消息为对象
 type ChatHistoryResp struct {
-	MessageID  uint64    `json:"message_id,string"`
-	SenderID   uint64    `json:"sender_id,string"`
-	SenderName string    `json:"sender_name"`
-	Status     uint8     `json:"status"`
-	UpdatedAt  time.Time `json:"updated_at"`
-	Content    any       `json:"content"`
+	MessageID  uint64          `json:"message_id,string"`
+	SenderID   uint64          `json:"sender_id,string"`
+	SenderName string          `json:"sender_name"`
+	Status     uint8           `json:"status"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	Content    json.RawMessage `json:"content"`
+	// 除发送者外已读该消息的人数，私聊中为1表示对方已读
+	ReadCount int `json:"read_count"`
+	// 回复的消息的预览，不是回复时不返回
+	ReplyTo json.RawMessage `json:"reply_to,omitempty"`
+	// 消息是否被编辑过，以及最后一次编辑的时间
+	Edited   bool       `json:"edited"`
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	// 表情回应，没有回应时不返回，websocket 推送的消息中也不返回
+	Reactions []ReactionResp `gorm:"-" json:"reactions,omitempty"`
+}
+
+// ReactionResp 一条消息上某个表情的回应人数
+// ReactedByMe 表示当前用户是否用该表情回应过
+type ReactionResp struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
+
+// ReactionCountResp 推送中的表情回应人数，不区分用户
+type ReactionCountResp struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// ReactResp 表情回应后的结果，Emoji 为当前用户现在的回应，取消时为空
+type ReactResp struct {
+	Emoji     string         `json:"emoji"`
+	Reactions []ReactionResp `json:"reactions"`
+}
+
+// MessageReactionsResp 离线同步中表情回应有变化的消息
+// Reactions 为该消息现在所有的回应，全部取消时为空数组
+type MessageReactionsResp struct {
+	ConversationID uint64         `json:"conversation_id,string"`
+	MessageID      uint64         `json:"message_id,string"`
+	Reactions      []ReactionResp `json:"reactions"`
+}
+
+// MessageReactionEventResp websocket 推送的表情回应变化
+// Emoji 为该用户现在的回应，取消时为空
+type MessageReactionEventResp struct {
+	ConversationID uint64              `json:"conversation_id,string"`
+	MessageID      uint64              `json:"message_id,string"`
+	UserID         uint64              `json:"user_id,string"`
+	Emoji          string              `json:"emoji"`
+	Reactions      []ReactionCountResp `json:"reactions"`
+}
+
+// ChatHistoryPageResp 分页加载聊天记录返回体
+// Messages 按消息ID从新到旧排列，NextCursor 用作下一次请求的 before 或 after
+type ChatHistoryPageResp struct {
+	Messages   []ChatHistoryResp `json:"messages"`
+	NextCursor uint64            `json:"next_cursor,string"`
+	HasMore    bool              `json:"has_more"`
+}
+
+// MessageEventResp websocket 推送的消息体
+// 在聊天记录返回体的基础上带上会话ID
+type MessageEventResp struct {
+	ConversationID uint64 `json:"conversation_id,string"`
+	ChatHistoryResp
+}
+
+// MessageReadEventResp websocket 推送的已读回执
+type MessageReadEventResp struct {
+	ConversationID    uint64 `json:"conversation_id,string"`
+	UserID            uint64 `json:"user_id,string"`
+	LastReadMessageID uint64 `json:"last_read_message_id,string"`
+}
+
+// MessageRecalledEventResp websocket 推送的撤回消息体
+type MessageRecalledEventResp struct {
+	ConversationID uint64 `json:"conversation_id,string"`
+	MessageID      uint64 `json:"message_id,string"`
+}
+
+// FileScannedEventResp websocket 推送的文件扫描结果
+type FileScannedEventResp struct {
+	ConversationID uint64 `json:"conversation_id,string"`
+	MessageID      uint64 `json:"message_id,string"`
+	ScanStatus     uint8  `json:"scan_status"`
+	// 通过扫描且已经生成了缩略图时才有
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+}
+
+// FileMediaEventResp websocket 推送的文件媒体信息，在后台处理完文件内容后推送
+type FileMediaEventResp struct {
+	ConversationID uint64 `json:"conversation_id,string"`
+	MessageID      uint64 `json:"message_id,string"`
+	Width          *int   `json:"width,omitempty"`
+	Height         *int   `json:"height,omitempty"`
+	PageCount      *int   `json:"page_count,omitempty"`
+	DurationMs     *int64 `json:"duration_ms,omitempty"`
+	// 通过扫描且生成了缩略图时才有
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+}
+
+// MessageRevisionResp 消息编辑前的一个版本
+// EditedAt 为该内容被替换的时间
+type MessageRevisionResp struct {
+	Text     string    `json:"text"`
+	EditedAt time.Time `json:"edited_at"`
+}
+
+// ForwardResp 转发到一个会话后生成的消息
+type ForwardResp struct {
+	ConversationID uint64   `json:"conversation_id,string"`
+	MessageIDs     []string `json:"message_ids"`
+}
+
+// ChatRecordResp 聊天记录的内容
+type ChatRecordResp struct {
+	Title string               `json:"title"`
+	Items []ChatRecordItemResp `json:"items"`
+}
+
+// ChatRecordItemResp 聊天记录中的一条消息
+// Content 的格式与聊天记录中的 content 相同，文件多了下载地址 download_url
+type ChatRecordItemResp struct {
+	ItemID     uint64          `json:"item_id,string"`
+	SenderID   uint64          `json:"sender_id,string"`
+	SenderName string          `json:"sender_name"`
+	SentAt     time.Time       `json:"sent_at"`
+	Status     uint8           `json:"status"`
+	Content    json.RawMessage `json:"content"`
 }
 
 // SendFileResp 发送文件返回体
@@ -82,4 +229,118 @@ type SendFileResp struct {
 	FileName  string `json:"file_name"`
 	FileSize  int64  `json:"file_size"`
 	FileType  string `json:"file_type"`
+	// 安全扫描的状态，0 通过，1 等待扫描，2 未通过，3 扫描失败
+	ScanStatus uint8 `json:"scan_status"`
+}
+
+// SyncConversationResp 离线同步中发生变化的会话
+// Hidden 为 true 表示用户在聊天列表中隐藏了该会话
+type SyncConversationResp struct {
+	ConversationID uint64 `json:"conversation_id,string"`
+	Remark         string `json:"remark"`
+	UnreadCount    int    `json:"unread_count"`
+	Role           uint8  `json:"role"`
+	Hidden         bool   `json:"hidden"`
+	// 当前用户在该会话中已读到的消息，用于多端同步已读状态
+	LastReadMessageID uint64 `json:"last_read_message_id,string"`
+	ConversationSettings
+}
+
+// SyncResp 离线同步返回体
+// ConversationIDs 是用户当前所在的全部会话，客户端可以据此发现自己被移出的会话
+type SyncResp struct {
+	Messages          []MessageEventResp         `json:"messages"`
+	Recalled          []MessageRecalledEventResp `json:"recalled"`
+	Edited            []MessageEventResp         `json:"edited"`
+	Reactions         []MessageReactionsResp     `json:"reactions"`
+	DeletedMessageIDs []string                   `json:"deleted_message_ids"`
+	Conversations     []SyncConversationResp     `json:"conversations"`
+	ConversationIDs   []string                   `json:"conversation_ids"`
+	NextCursor        uint64                     `json:"next_cursor,string"`
+	HasMore           bool                       `json:"has_more"`
+}
+
+// PresenceResp 用户在线状态
+type PresenceResp struct {
+	UserID     uint64     `gorm:"column:id" json:"user_id,string"`
+	Online     bool       `gorm:"-" json:"online"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+}
+
+// TypingEventResp websocket 推送的正在输入状态
+type TypingEventResp struct {
+	ConversationID uint64 `json:"conversation_id,string"`
+	UserID         uint64 `json:"user_id,string"`
+	Typing         bool   `json:"typing"`
+}
+
+// SearchMessageResp 搜索消息返回的单条结果
+// Highlight 为命中的文本或文件名，命中部分用 <em></em> 包裹，其余部分已做 html 转义
+type SearchMessageResp struct {
+	MessageEventResp
+	Highlight string `gorm:"-" json:"highlight"`
+}
+
+// StarredMessageResp 收藏的消息，带上消息所在的会话
+// ConversationName 为会话备注，没有备注时群聊为群名，私聊为对方的名字
+// Highlight 只在搜索收藏时返回
+type StarredMessageResp struct {
+	MessageEventResp
+	ConversationType uint8     `json:"conversation_type"`
+	ConversationName string    `json:"conversation_name"`
+	StarredAt        time.Time `json:"starred_at"`
+	Highlight        string    `gorm:"-" json:"highlight,omitempty"`
+}
+
+// StarredMessagePageResp 收藏消息返回体
+type StarredMessagePageResp struct {
+	Messages   []StarredMessageResp `json:"messages"`
+	NextCursor uint64               `json:"next_cursor,string"`
+	HasMore    bool                 `json:"has_more"`
+}
+
+// SearchMessagePageResp 搜索消息返回体
+type SearchMessagePageResp struct {
+	Messages   []SearchMessageResp `json:"messages"`
+	NextCursor uint64              `json:"next_cursor,string"`
+	HasMore    bool                `json:"has_more"`
+}
+
+// SearchFileResp 语义搜索文件返回的单条结果
+// Score 为余弦相似度，越接近 1 越相关；Snippet 为提取出的文件内容开头部分
+type SearchFileResp struct {
+	MessageEventResp
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+// UploadSessionResp 分片上传会话的状态
+// UploadedChunks 为已上传分片的序号，从 0 开始
+type UploadSessionResp struct {
+	UploadID       uint64    `json:"upload_id,string"`
+	FileName       string    `json:"file_name"`
+	FileSize       int64     `json:"file_size"`
+	ChunkSize      int64     `json:"chunk_size"`
+	ChunkCount     int       `json:"chunk_count"`
+	UploadedChunks []int     `json:"uploaded_chunks"`
+	Completed      bool      `json:"completed"`
+	MessageID      uint64    `json:"message_id,string,omitempty"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// UploadChunkResp 上传分片的返回体
+type UploadChunkResp struct {
+	ChunkIndex int    `json:"chunk_index"`
+	Size       int64  `json:"size"`
+	Checksum   string `json:"checksum"`
+}
+
+// StorageUsageResp 用户的存储用量，配额为 0 表示不限制
+// UsedBytes 为自己发送且未撤回的文件大小之和，PendingBytes 为未完成的分片上传预占的大小
+type StorageUsageResp struct {
+	UsedBytes    int64 `json:"used_bytes"`
+	FileCount    int64 `json:"file_count"`
+	PendingBytes int64 `json:"pending_bytes"`
+	QuotaBytes   int64 `json:"quota_bytes"`
+	MaxFileSize  int64 `json:"max_file_size"`
 }
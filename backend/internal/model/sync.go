@@ -0,0 +1,22 @@
+package model
+
+import (
+	"github.com/lojes7/inquire/pkg/utils"
+	"gorm.io/gorm"
+)
+
+// SyncCursor 用户离线同步的游标
+// 每个用户的每台设备各自记录一个，游标本身是一个雪花ID
+type SyncCursor struct {
+	MyModel
+	UserID   uint64 `gorm:"type:bigint;not null;uniqueIndex:idx_sync_user_device"`
+	DeviceID string `gorm:"type:varchar(64);not null;uniqueIndex:idx_sync_user_device"`
+	Cursor   uint64 `gorm:"type:bigint;not null"`
+}
+
+func (s *SyncCursor) BeforeCreate(db *gorm.DB) error {
+	if s.ID == 0 {
+		s.ID = utils.NewUniqueID()
+	}
+	return nil
+}
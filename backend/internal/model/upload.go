@@ -0,0 +1,58 @@
+package model
+
+import (
+	"time"
+
+	"github.com/lojes7/inquire/pkg/utils"
+	"gorm.io/gorm"
+)
+
+// 分片上传会话的状态
+const (
+	UPLOADING uint8 = iota
+	COMPLETING
+	COMPLETED
+)
+
+// UploadSession 分片上传会话
+// 分片先写入存储的 uploads/<会话ID>/<序号>，全部上传后合并为一个文件并发送文件消息
+type UploadSession struct {
+	MyModel
+	UserID         uint64 `gorm:"type:bigint;not null;index"`
+	ConversationID uint64 `gorm:"type:bigint;not null"`
+	FileName       string `gorm:"type:varchar(255);not null"`
+	FileSize       int64  `gorm:"not null"`
+	ChunkSize      int64  `gorm:"not null"`
+	ChunkCount     int    `gorm:"not null"`
+	// 整个文件的 sha256，十六进制小写
+	Checksum  string    `gorm:"type:char(64);not null"`
+	Status    uint8     `gorm:"type:smallint;default:0"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+	// 合并完成后生成的文件消息
+	MessageID uint64 `gorm:"type:bigint;default:0"`
+	// 文件消息回复的消息
+	ReplyToMessageID uint64 `gorm:"type:bigint;default:0"`
+}
+
+// UploadChunk 已上传的分片
+type UploadChunk struct {
+	MyModel
+	UploadID   uint64 `gorm:"type:bigint;not null;uniqueIndex:idx_upload_chunk"`
+	ChunkIndex int    `gorm:"not null;uniqueIndex:idx_upload_chunk"`
+	Size       int64  `gorm:"not null"`
+	Checksum   string `gorm:"type:char(64);not null"`
+}
+
+func (u *UploadSession) BeforeCreate(db *gorm.DB) error {
+	if u.ID == 0 {
+		u.ID = utils.NewUniqueID()
+	}
+	return nil
+}
+
+func (u *UploadChunk) BeforeCreate(db *gorm.DB) error {
+	if u.ID == 0 {
+		u.ID = utils.NewUniqueID()
+	}
+	return nil
+}
@@ -19,6 +19,8 @@ type User struct {
 	Gender      string         `gorm:"type:varchar(12);check:gender IN ('male','female','')"`
 	DeletedAt   gorm.DeletedAt `gorm:"index"`
 	CreatedAt   time.Time      `gorm:"not null;autoCreateTime"`
+	// 最后一次上线或下线的时间
+	LastSeenAt *time.Time `gorm:"type:timestamptz"`
 }
 
 func NewUser(name string, password string, phone string) (*User, error) {
@@ -3,6 +3,7 @@ package router
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/lojes7/inquire/internal/handler"
+	"github.com/lojes7/inquire/internal/service"
 	"github.com/lojes7/inquire/internal/ws"
 	"github.com/lojes7/inquire/pkg/middleware"
 )
@@ -12,9 +13,10 @@ func Launch() *gin.Engine {
 
 	// 跨域中间件
 	r.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")                            // 允许所有域名访问
-		c.Header("Access-Control-Allow-Methods", "GET, POST, DELETE")           // 允许的HTTP方法
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization") // 允许的请求头
+		c.Header("Access-Control-Allow-Origin", "*")                                                                                                 // 允许所有域名访问
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")                                                                    // 允许的HTTP方法
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Chunk-Checksum, Range, If-None-Match, If-Modified-Since, If-Range") // 允许的请求头
+		c.Header("Access-Control-Expose-Headers", "Content-Range, Content-Disposition, ETag, Last-Modified, Accept-Ranges")                          // 允许前端读取的响应头
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204) // 对于预检请求，直接返回成功
 			return
@@ -35,16 +37,22 @@ func Launch() *gin.Engine {
 		auth := api.Group("/auth", middleware.JWTAuth())
 		{
 			// websocket
+			handler.RegisterWsCommands()
+			ws.GetHub().OnPresenceChange(service.UpdatePresence)
 			auth.GET("/ws", func(c *gin.Context) {
 				ws.ServeWs(ws.GetHub(), c)
 			})
 
+			// 离线同步
+			auth.GET("/sync", handler.Sync)
+
 			// 修改个人信息
 			me := auth.Group("/me")
 			{
 				me.POST("/uid", handler.ReviseUid)           //修改微信号
 				me.POST("/password", handler.RevisePassword) // 修改密码
 				me.POST("/name", handler.ReviseName)         // 修改用户名
+				me.GET("/storage", handler.StorageUsage)     // 查看存储用量
 			}
 
 			// 查看他人信息
@@ -69,6 +77,7 @@ func Launch() *gin.Engine {
 			friendship := auth.Group("/friendships")
 			{
 				friendship.GET("", handler.FriendshipList)                  //加载好友列表
+				friendship.GET("/presence", handler.FriendsPresence)        // 好友在线状态
 				friendship.DELETE("/:friend_id", handler.DeleteFriendship)  //删除好友
 				friendship.POST("/remark/:friend_id", handler.ReviseRemark) //修改好友备注
 			}
@@ -76,25 +85,58 @@ func Launch() *gin.Engine {
 			// 消息相关
 			message := auth.Group("/messages")
 			{
-				message.POST("/text", handler.SendText)          //发送文本消息
-				message.POST("/file", handler.SendFile)          // 发送文件
-				message.DELETE("/recall", handler.RecallMessage) //撤回消息
-				message.DELETE("/delete", handler.DeleteMessage) //删除消息
+				message.POST("/text", handler.SendText)                         //发送文本消息
+				message.POST("/file", handler.SendFile)                         // 发送文件
+				message.DELETE("/recall", handler.RecallMessage)                //撤回消息
+				message.DELETE("/delete", handler.DeleteMessage)                //删除消息
+				message.GET("/search", handler.SearchMessages)                  // 搜索消息
+				message.PATCH("/:message_id", handler.EditMessage)              // 编辑消息
+				message.GET("/:message_id/revisions", handler.MessageRevisions) // 查看编辑历史
+				message.POST("/forward", handler.ForwardMessages)               // 转发消息
+				message.GET("/:message_id/record", handler.ChatRecord)          // 查看聊天记录
+				message.POST("/:message_id/reactions", handler.ToggleReaction)  // 表情回应
+				message.PUT("/:message_id/star", handler.StarMessage)           // 收藏消息
+				message.DELETE("/:message_id/star", handler.UnstarMessage)      // 取消收藏
+				message.GET("/starred", handler.StarredMessages)                // 查看和搜索收藏
 			}
 
 			// 会话相关
 			converse := auth.Group("/conversations")
 			{
-				converse.GET("", handler.ConversationList)                  // 加载聊天列表
-				converse.POST("/private", handler.StartPrivateConversation) // 发起私聊
-				converse.POST("/group")                                     //创建群聊
-				converse.GET("/:conversation_id", handler.ChatHistoryList)  // 加载聊天记录
+				converse.GET("", handler.ConversationList)                                       // 加载聊天列表
+				converse.POST("/private", handler.StartPrivateConversation)                      // 发起私聊
+				converse.POST("/group", handler.CreateGroupConversation)                         // 创建群聊
+				converse.GET("/:conversation_id", handler.ChatHistoryList)                       // 加载聊天记录
+				converse.POST("/:conversation_id/read", handler.MarkConversationRead)            // 标记已读
+				converse.PATCH("/:conversation_id/settings", handler.UpdateConversationSettings) // 修改会话设置
+				converse.DELETE("/:conversation_id", handler.HideConversation)                   // 隐藏会话
+				converse.POST("/:conversation_id/clear", handler.ClearConversation)              // 清空聊天记录
+
+				// 群成员管理
+				converse.POST("/group/:conversation_id/members", handler.InviteGroupMembers)             // 邀请成员
+				converse.DELETE("/group/:conversation_id/members/:member_id", handler.RemoveGroupMember) // 移除成员
+				converse.POST("/group/:conversation_id/leave", handler.LeaveGroup)                       // 退出群聊
+				converse.POST("/group/:conversation_id/owner", handler.TransferGroupOwner)               // 转让群主
+				converse.POST("/group/:conversation_id/admins", handler.SetGroupAdmin)                   // 设置或取消管理员
 			}
 
 			// 文件相关
 			file := auth.Group("/files")
 			{
-				file.GET("/:message_id", handler.DownloadFile) // 下载文件
+				file.GET("/search", handler.SearchFiles)                                // 按内容搜索文件
+				file.GET("/:message_id", handler.DownloadFile)                          // 下载文件
+				file.GET("/:message_id/thumbnail", handler.FileThumbnail)               // 获取缩略图
+				file.GET("/:message_id/items/:item_id", handler.DownloadChatRecordFile) // 下载聊天记录中的文件
+			}
+
+			// 分片上传
+			upload := auth.Group("/uploads")
+			{
+				upload.POST("", handler.CreateUpload)                        // 创建上传会话
+				upload.GET("/:upload_id", handler.UploadStatus)              // 查询已上传的分片
+				upload.PUT("/:upload_id/chunks/:index", handler.UploadChunk) // 上传分片
+				upload.POST("/:upload_id/complete", handler.CompleteUpload)  // 合并分片并发送文件
+				upload.DELETE("/:upload_id", handler.AbortUpload)            // 取消上传
 			}
 		}
 	}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/pkg/infra"
+	"github.com/lojes7/inquire/pkg/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// blobGCGracePeriod 引用计数归零后保留的时间
+	// 上传开始时会刷新 updated_at，保证上传过程中内容不会被回收
+	blobGCGracePeriod = time.Hour
+	// blobGCInterval 垃圾回收的间隔
+	blobGCInterval = time.Hour
+)
+
+// blobKey Blob 在存储中的 key，按 hash 前两位分目录
+func blobKey(hash string) string {
+	return "blobs/" + hash[:2] + "/" + hash
+}
+
+// reserveBlob 在写入内容之前登记 Blob
+// 不存在时创建引用计数为 0 的记录，已存在时刷新 updated_at 避免被回收
+// 返回的记录引用计数为 0 时调用方需要把内容写入存储，写入相同内容是幂等的
+// 垃圾回收正在删除同一 hash 时，插入会等待删除完成后创建新记录
+func reserveBlob(hash string, size int64, contentType string) (*model.Blob, error) {
+	db := infra.GetDB()
+	now := time.Now()
+
+	var blob model.Blob
+	res := db.Raw(`INSERT INTO blobs (id, created_at, updated_at, hash, size, content_type, storage_key, ref_count)
+			VALUES (?, ?, ?, ?, ?, ?, ?, 0)
+			ON CONFLICT (hash) DO UPDATE SET updated_at = EXCLUDED.updated_at
+			RETURNING *`,
+		utils.NewUniqueID(), now, now, hash, size, contentType, blobKey(hash)).
+		Scan(&blob)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return nil, errors.New("服务器错误")
+	}
+	return &blob, nil
+}
+
+// acquireBlob 增加一次引用，需要与创建文件记录在同一个事务中
+func acquireBlob(tx *gorm.DB, blobID uint64) error {
+	res := tx.Model(&model.Blob{}).
+		Where("id = ?", blobID).
+		Updates(map[string]any{
+			"ref_count":  gorm.Expr("ref_count + 1"),
+			"updated_at": time.Now(),
+		})
+	if res.Error != nil {
+		log.Println(res.Error)
+		return errors.New("服务器错误")
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("文件内容不存在")
+	}
+	return nil
+}
+
+// releaseBlob 减少一次引用，blobID 为 0（早期没有 Blob 的记录）时什么也不做
+func releaseBlob(tx *gorm.DB, blobID uint64) error {
+	if blobID == 0 {
+		return nil
+	}
+	res := tx.Model(&model.Blob{}).
+		Where("id = ? AND ref_count > 0", blobID).
+		Updates(map[string]any{
+			"ref_count":  gorm.Expr("ref_count - 1"),
+			"updated_at": time.Now(),
+		})
+	if res.Error != nil {
+		log.Println(res.Error)
+		return errors.New("服务器错误")
+	}
+	return nil
+}
+
+// releaseMessageBlob 释放文件消息对 Blob 的引用
+func releaseMessageBlob(tx *gorm.DB, messageID uint64) error {
+	var blobIDs []uint64
+	err := tx.Model(&model.File{}).
+		Where("message_id = ?", messageID).
+		Pluck("blob_id", &blobIDs).Error
+	if err != nil {
+		log.Println(err)
+		return errors.New("服务器错误")
+	}
+	for _, blobID := range blobIDs {
+		err = releaseBlob(tx, blobID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CollectBlobs 定期删除引用计数为 0 且超过保留时间的 Blob，需要在单独的 goroutine 中运行
+func CollectBlobs() {
+	ticker := time.NewTicker(blobGCInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		collectBlobs()
+	}
+}
+
+func collectBlobs() {
+	db := infra.GetDB()
+
+	var blobs []model.Blob
+	err := db.Where("ref_count = 0 AND updated_at < ?", time.Now().Add(-blobGCGracePeriod)).
+		Limit(1000).
+		Find(&blobs).Error
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for i := range blobs {
+		collectBlob(db, &blobs[i])
+	}
+}
+
+// collectBlob 删除一个 Blob 的内容和记录
+// 删除内容期间锁住记录，同一 hash 的 reserveBlob 会等待删除完成后重新插入记录再写入内容，
+// 避免先删记录后删内容时，新写入的内容被删除
+func collectBlob(db *gorm.DB, blob *model.Blob) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 重新检查条件，期间被引用或刷新过的不删除
+		var locked model.Blob
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND ref_count = 0 AND updated_at < ?", blob.ID, time.Now().Add(-blobGCGracePeriod)).
+			Take(&locked).Error
+		if err != nil {
+			return err
+		}
+
+		if err := infra.GetStorage().Delete(context.Background(), locked.StorageKey); err != nil {
+			return err
+		}
+		if locked.HasThumbnail {
+			deleteThumbnails(&locked)
+		}
+		return tx.Unscoped().Delete(&locked).Error
+	})
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Println(err)
+	}
+}
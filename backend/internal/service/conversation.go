@@ -3,11 +3,13 @@ package service
 import (
 	"errors"
 	"log"
+	"slices"
 
 	"github.com/lojes7/inquire/internal/model"
 	"github.com/lojes7/inquire/pkg/infra"
 	"github.com/lojes7/inquire/pkg/utils"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // StartPrivateConversation 发起私聊
@@ -24,7 +26,7 @@ func StartPrivateConversation(userID, friendID uint64) (uint64, error) {
 
 	// 第二步：不管用户有没有删除该会话，都更新该用户的 conversation_users 记录
 	// 使该会话在可能被删除的情况下重新出现
-	res := db.Model(&model.ConversationUser{}).
+	res := db.Unscoped().Model(&model.ConversationUser{}).
 		Where("user_id = ? AND conversation_id = ?", userID, conversationID).
 		Update("deleted_at", nil)
 
@@ -36,74 +38,205 @@ func StartPrivateConversation(userID, friendID uint64) (uint64, error) {
 	return conversationID, nil
 }
 
-// ChatHistoryList 加载聊天记录
-func ChatHistoryList(userID, conversationID uint64) ([]model.ChatHistoryResp, error) {
-	db := infra.GetDB()
-
-	resp := make([]model.ChatHistoryResp, 0)
+// chatMessageSelect 查询聊天消息的公共部分，聊天记录和 websocket 推送共用
+// 需要依次传入 chatMessageArgs 作为参数
+const chatMessageSelect = `SELECT ` + chatMessageColumns + chatMessageFrom
 
-	sql := `SELECT m.id AS message_id, 
-       		m.sender_id, 
-       		u.name AS sender_name,
-			m.status, 
+// chatMessageColumns 聊天消息的查询列，需要追加其它列时与 chatMessageFrom 分开拼接
+const chatMessageColumns = `m.id AS message_id,
+			m.conversation_id,
+			m.sender_id,
+			u.name AS sender_name,
+			m.status,
 			m.updated_at,
-			CASE m.status
-			WHEN ? OR ? THEN t.text
-			WHEN ? THEN json_build_object(
-               'file_name', f.file_name,
-               'file_url', f.file_url,
-               'file_size', f.file_size,
-               'file_type', f.file_type
-           )
-			ELSE ''
-			END AS content
+			m.edited_at IS NOT NULL AS edited,
+			m.edited_at,
+			CASE
+			WHEN m.status IN (?, ?) THEN to_jsonb(t.text)
+			WHEN m.status = ? THEN jsonb_build_object(
+				'file_name', f.file_name,
+				'file_url', f.file_url,
+				'file_size', f.file_size,
+				'file_type', f.file_type,
+				'scan_status', f.scan_status
+			) || jsonb_strip_nulls(jsonb_build_object(
+				'width', b.width,
+				'height', b.height,
+				'page_count', b.page_count,
+				'duration_ms', b.duration_ms,
+				'thumbnail_url', CASE WHEN b.has_thumbnail AND f.scan_status = ? THEN '/api/auth/files/' || m.id || '/thumbnail' END
+			))
+			WHEN m.status = ? THEN jsonb_build_object(
+				'title', t.text,
+				'count', (SELECT COUNT(*) FROM chat_record_items ci WHERE ci.record_id = m.id),
+				'preview', (SELECT COALESCE(jsonb_agg(p.line ORDER BY p.id), '[]'::jsonb) FROM (
+					SELECT ci.id, ci.sender_name || ': ' || CASE
+						WHEN ci.status = ? THEN ?::text || ci.file_name || ci.file_ext
+						ELSE left(ci.text, ?)
+					END AS line
+					FROM chat_record_items ci
+					WHERE ci.record_id = m.id
+					ORDER BY ci.id
+					LIMIT ?) p)
+			)
+			ELSE to_jsonb(''::text)
+			END AS content,
+			(SELECT COUNT(*) FROM conversation_users rc
+				WHERE rc.conversation_id = m.conversation_id
+				AND rc.user_id != m.sender_id
+				AND rc.last_read_message_id >= m.id) AS read_count,
+			CASE WHEN m.reply_to_message_id != 0 THEN jsonb_build_object(
+				'message_id', m.reply_to_message_id::text,
+				'sender_id', rm.sender_id::text,
+				'sender_name', ru.name,
+				'status', COALESCE(rm.status, ?),
+				'preview', CASE
+					WHEN rm.id IS NULL OR rm.status = ? THEN ?
+					WHEN rm.status = ? THEN rf.file_name || rf.file_ext
+					WHEN char_length(rt.text) > ? THEN left(rt.text, ?) || '…'
+					ELSE rt.text
+				END
+			) END AS reply_to`
+
+const chatMessageFrom = `
 			FROM messages m
 			LEFT JOIN users u ON u.id = m.sender_id
-			LEFT JOIN message_users mu ON mu.message_id = m.id AND mu.user_id = ? 
 			LEFT JOIN texts t ON t.message_id = m.id
 			LEFT JOIN files f ON f.message_id = m.id
-			WHERE m.conversation_id = ? AND m.status != ? AND mu.deleted_at IS NULL
-			ORDER BY m.updated_at DESC`
+			LEFT JOIN blobs b ON b.id = f.blob_id
+			LEFT JOIN messages rm ON rm.id = m.reply_to_message_id AND m.reply_to_message_id != 0
+			LEFT JOIN users ru ON ru.id = rm.sender_id
+			LEFT JOIN texts rt ON rt.message_id = rm.id
+			LEFT JOIN files rf ON rf.message_id = rm.id`
+
+func chatMessageArgs() []any {
+	return []any{model.TEXT, model.SYSTEM, model.FILE, model.SCAN_CLEAN,
+		model.CHAT_RECORD, model.FILE, chatRecordFilePrefix, replyPreviewRunes, chatRecordPreviewItems,
+		model.RECALLED, model.RECALLED, replyRecalledPreview, model.FILE, replyPreviewRunes, replyPreviewRunes}
+}
 
-	res := db.Raw(sql, model.TEXT,
-		model.SYSTEM,
-		model.FILE,
-		userID,
-		conversationID,
-		model.RECALLED).
-		Scan(&resp)
+const (
+	// replyPreviewRunes 回复预览中文本的最大字符数，超出部分用省略号代替
+	replyPreviewRunes = 50
+	// replyRecalledPreview 被回复的消息已撤回时的预览
+	replyRecalledPreview = "消息已撤回"
+)
+
+// defaultChatHistoryLimit 未指定时每页加载的消息数
+const defaultChatHistoryLimit = 20
+
+// ChatHistoryList 分页加载聊天记录
+// 按雪花ID排序：传 before 向前翻更早的消息，传 after 加载更新的消息
+// 无论哪个方向，返回的消息都按从新到旧排列
+func ChatHistoryList(userID, conversationID uint64, req model.ChatHistoryReq) (*model.ChatHistoryPageResp, error) {
+	db := infra.GetDB()
+
+	ok, err := isConversationMember(db, userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("无权限查看该会话")
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultChatHistoryLimit
+	}
+
+	sql := chatMessageSelect + `
+			LEFT JOIN message_users mu ON mu.message_id = m.id AND mu.user_id = ?
+			WHERE m.conversation_id = ? AND m.status != ? AND mu.deleted_at IS NULL`
+	args := append(chatMessageArgs(), userID, conversationID, model.RECALLED)
+
+	// 向后加载时只返回已经稳定的消息，游标之前不会再出现新提交的消息，见 stableMessageID
+	// 传入的游标超过上界时退回到上界，重复返回的消息由客户端按ID去重
+	stable := stableMessageID()
+	after := min(req.After, stable)
+	switch {
+	case req.After > 0:
+		sql += ` AND m.id > ? AND m.id <= ? ORDER BY m.id ASC LIMIT ?`
+		args = append(args, after, stable, limit+1)
+	case req.Before > 0:
+		sql += ` AND m.id < ? ORDER BY m.id DESC LIMIT ?`
+		args = append(args, req.Before, limit+1)
+	default:
+		sql += ` ORDER BY m.id DESC LIMIT ?`
+		args = append(args, limit+1)
+	}
 
+	messages := make([]model.ChatHistoryResp, 0, limit+1)
+	res := db.Raw(sql, args...).Scan(&messages)
 	if res.Error != nil {
 		log.Println(res.Error)
 		return nil, errors.New("服务器错误")
 	}
-	return resp, nil
+
+	// 多查一条用来判断是否还有下一页
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	views := make([]*model.ChatHistoryResp, 0, len(messages))
+	for i := range messages {
+		views = append(views, &messages[i])
+	}
+	if err := attachReactions(userID, views); err != nil {
+		return nil, err
+	}
+
+	// 没有新消息时返回传入的游标（超过上界时为上界），客户端可以继续用它轮询
+	nextCursor := req.Before
+	if req.After > 0 {
+		nextCursor = after
+		if len(messages) > 0 {
+			nextCursor = messages[len(messages)-1].MessageID
+		}
+		slices.Reverse(messages)
+	} else if len(messages) > 0 {
+		nextCursor = messages[len(messages)-1].MessageID
+	}
+
+	return &model.ChatHistoryPageResp{
+		Messages:   messages,
+		NextCursor: nextCursor,
+		HasMore:    hasMore,
+	}, nil
 }
 
-// ConversationList 会话列表
-func ConversationList(userID uint64) ([]model.ConversationListResp, error) {
+// ConversationList 会话列表，archived 为 true 时只列出归档的会话，否则只列出未归档的会话
+// 置顶的会话排在前面，其余按最后一条消息从新到旧排列，修改设置、标记已读不会改变顺序
+// 免打扰到期后返回的 is_muted 为 false
+func ConversationList(userID uint64, archived bool) ([]model.ConversationListResp, error) {
 	db := infra.GetDB()
 	resp := make([]model.ConversationListResp, 0)
 
 	sql := `SELECT cu.remark, 
        	cu.conversation_id,
        	cu.unread_count,
-       	CASE m.status
-		WHEN ? OR ? THEN t.text
-		WHEN ? THEN f.file_name
+       	CASE
+		WHEN m.status IN (?, ?, ?) THEN t.text
+		WHEN m.status = ? THEN f.file_name
 		ELSE ''
-		END AS content
+		END AS content,
+		cu.is_pinned,
+		cu.is_muted AND (cu.muted_until IS NULL OR cu.muted_until > NOW()) AS is_muted,
+		CASE WHEN cu.muted_until > NOW() THEN cu.muted_until END AS muted_until,
+		cu.is_archived
 		FROM conversation_users cu 
 		LEFT JOIN messages m ON m.id = cu.last_message_id
 		LEFT JOIN files f ON f.message_id = m.id
 		LEFT JOIN texts t ON t.message_id = m.id
-		WHERE cu.user_id = ? AND cu.deleted_at IS NULL
-		ORDER BY cu.is_pinned DESC, cu.updated_at DESC `
+		WHERE cu.user_id = ? AND cu.deleted_at IS NULL AND cu.is_archived = ?
+		ORDER BY cu.is_pinned DESC, cu.last_message_id DESC, cu.created_at DESC `
 
 	res := db.Raw(sql, model.TEXT,
 		model.SYSTEM,
+		model.CHAT_RECORD,
 		model.FILE,
-		userID).
+		userID,
+		archived).
 		Scan(&resp)
 
 	if res.Error != nil {
@@ -168,7 +301,7 @@ func createPrivateConversation(userID, friendID uint64) (uint64, error) {
 }
 
 func CreateConversationUser(tx *gorm.DB, userID, conversationID uint64, remark string) error {
-	res := tx.Model(&model.ConversationUser{}).
+	res := tx.Unscoped().Model(&model.ConversationUser{}).
 		Where("user_id = ? AND conversation_id = ?", userID, conversationID).
 		Update("deleted_at", nil)
 	if res.Error != nil {
@@ -195,6 +328,8 @@ func CreateConversationUser(tx *gorm.DB, userID, conversationID uint64, remark s
 	return nil
 }
 
+// DeleteConversationUser 把会话从用户的会话列表中隐藏，只对自己生效
+// 聊天记录不受影响，会话中有新消息时会重新出现
 func DeleteConversationUser(userID, conversationID uint64) error {
 	db := infra.GetDB()
 	res := db.Where("user_id = ? AND conversation_id = ?", userID, conversationID).
@@ -205,8 +340,99 @@ func DeleteConversationUser(userID, conversationID uint64) error {
 		return errors.New("服务器错误")
 	}
 	if res.RowsAffected == 0 {
-		log.Println("删除 conversation_users 操作影响了0行表")
+		// 已经隐藏过的会话不算错误
+		ok, err := isConversationMember(db, userID, conversationID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("会话不存在")
+		}
 	}
 
 	return nil
 }
+
+// ClearConversation 清空用户在会话中的聊天记录，只对自己生效
+// 当前的所有消息都标记为被该用户删除，其他成员的聊天记录不受影响
+func ClearConversation(userID, conversationID uint64) error {
+	db := infra.GetDB()
+	ok, err := isConversationMember(db, userID, conversationID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("会话不存在")
+	}
+
+	// 离线同步按 deleted_at 查找删除的消息，需要限时提交
+	return messageTransaction(func(tx *gorm.DB) error {
+		now, err := transactionTime(tx)
+		if err != nil {
+			return err
+		}
+
+		// 先锁住自己的会话记录：发送消息时会更新所有成员的会话记录，
+		// 正在发送的消息提交后这里才继续，之后的查询能看到这些消息；
+		// 在这之后发送的消息要等清空完成才能提交，不会被清空，会重新成为最后一条消息
+		res := tx.Unscoped().Model(&model.ConversationUser{}).
+			Where("user_id = ? AND conversation_id = ?", userID, conversationID).
+			Updates(map[string]any{"last_message_id": 0, "unread_count": 0})
+		if res.Error != nil {
+			log.Println(res.Error)
+			return errors.New("服务器错误")
+		}
+
+		// 已有 message_users 记录的消息直接软删除
+		res = tx.Exec(`UPDATE message_users mu SET deleted_at = ?, updated_at = ?
+				FROM messages m
+				WHERE m.id = mu.message_id AND m.conversation_id = ?
+				AND mu.user_id = ? AND mu.deleted_at IS NULL`,
+			now, now, conversationID, userID)
+		if res.Error != nil {
+			log.Println(res.Error)
+			return errors.New("服务器错误")
+		}
+
+		// 其余的消息补上已删除的记录
+		var messageIDs []uint64
+		res = tx.Raw(`SELECT m.id FROM messages m
+				WHERE m.conversation_id = ?
+				AND NOT EXISTS (SELECT 1 FROM message_users mu WHERE mu.message_id = m.id AND mu.user_id = ?)`,
+			conversationID, userID).
+			Scan(&messageIDs)
+		if res.Error != nil {
+			log.Println(res.Error)
+			return errors.New("服务器错误")
+		}
+		if len(messageIDs) > 0 {
+			rows := make([]model.MessageUser, 0, len(messageIDs))
+			for _, messageID := range messageIDs {
+				rows = append(rows, model.MessageUser{
+					MyModel:   model.MyModel{UpdatedAt: now, DeletedAt: gorm.DeletedAt{Time: now, Valid: true}},
+					UserID:    userID,
+					MessageID: messageID,
+				})
+			}
+			res = tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, 500)
+			if res.Error != nil {
+				log.Println(res.Error)
+				return errors.New("服务器错误")
+			}
+		}
+		return nil
+	})
+}
+
+// isConversationMember 判断用户是否在会话中，隐藏了会话的用户仍然算作成员
+func isConversationMember(db *gorm.DB, userID, conversationID uint64) (bool, error) {
+	var cnt int64
+	err := db.Unscoped().Model(&model.ConversationUser{}).
+		Where("user_id = ? AND conversation_id = ?", userID, conversationID).
+		Count(&cnt).Error
+	if err != nil {
+		log.Println(err)
+		return false, errors.New("服务器错误")
+	}
+	return cnt > 0, nil
+}
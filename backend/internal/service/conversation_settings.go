@@ -0,0 +1,99 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/pkg/infra"
+	"gorm.io/gorm"
+)
+
+// UpdateConversationSettings 修改用户对会话的个人设置：置顶、免打扰、群备注和归档
+// 只修改请求中传了的字段，设置只对自己生效
+func UpdateConversationSettings(userID, conversationID uint64, req model.ConversationSettingsReq) (*model.ConversationSettingsResp, error) {
+	db := infra.GetDB()
+	var cu model.ConversationUser
+	err := db.Unscoped().
+		Where("user_id = ? AND conversation_id = ?", userID, conversationID).
+		Take(&cu).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("会话不存在")
+		}
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+
+	updates := make(map[string]any)
+	if req.IsPinned != nil {
+		updates["is_pinned"] = *req.IsPinned
+		cu.IsPinned = *req.IsPinned
+	}
+	switch {
+	case req.IsMuted != nil && !*req.IsMuted:
+		updates["is_muted"] = false
+		updates["muted_until"] = nil
+		cu.IsMuted, cu.MutedUntil = false, nil
+	case req.MutedUntil != nil:
+		if !req.MutedUntil.After(time.Now()) {
+			return nil, errors.New("免打扰的结束时间必须晚于现在")
+		}
+		updates["is_muted"] = true
+		updates["muted_until"] = *req.MutedUntil
+		cu.IsMuted, cu.MutedUntil = true, req.MutedUntil
+	case req.IsMuted != nil:
+		updates["is_muted"] = true
+		updates["muted_until"] = nil
+		cu.IsMuted, cu.MutedUntil = true, nil
+	}
+	if req.Remark != nil {
+		var conv model.Conversation
+		err = db.Select("type").Where("id = ?", conversationID).Take(&conv).Error
+		if err != nil {
+			log.Println(err)
+			return nil, errors.New("服务器错误")
+		}
+		if conv.Type != model.GROUP {
+			return nil, errors.New("只能为群聊设置备注，私聊请修改好友备注")
+		}
+		updates["remark"] = *req.Remark
+		cu.Remark = *req.Remark
+	}
+	if req.IsArchived != nil {
+		updates["is_archived"] = *req.IsArchived
+		cu.IsArchived = *req.IsArchived
+	}
+	if len(updates) == 0 {
+		return nil, errors.New("没有要修改的设置")
+	}
+
+	// 通过 updated_at 的变化同步到用户的其它设备
+	res := db.Unscoped().Model(&model.ConversationUser{}).
+		Where("id = ?", cu.ID).
+		Updates(updates)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return nil, errors.New("服务器错误")
+	}
+
+	return &model.ConversationSettingsResp{
+		ConversationID:       conversationID,
+		Remark:               cu.Remark,
+		ConversationSettings: conversationSettings(&cu, time.Now()),
+	}, nil
+}
+
+// conversationSettings 取出会话的个人设置，免打扰到期后视为未开启
+func conversationSettings(cu *model.ConversationUser, now time.Time) model.ConversationSettings {
+	settings := model.ConversationSettings{
+		IsPinned:   cu.IsPinned,
+		IsArchived: cu.IsArchived,
+	}
+	if cu.IsMuted && (cu.MutedUntil == nil || cu.MutedUntil.After(now)) {
+		settings.IsMuted = true
+		settings.MutedUntil = cu.MutedUntil
+	}
+	return settings
+}
@@ -1,50 +1,70 @@
 package service
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
-	"log"
 	"mime/multipart"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/pkg/infra"
 )
 
-func saveFile(file *multipart.FileHeader, dst string) error {
+// saveFile 把上传的文件写入存储，返回对应的 Blob
+// 先按内容检测文件类型并检查是否允许上传，再计算内容的 sha256，存储中已有相同内容时不再重复写入
+func saveFile(file *multipart.FileHeader) (*model.Blob, error) {
 	src, err := file.Open()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer src.Close()
 
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
+	// 读取前 512 字节用于检测，同时计算整个文件的 sha256
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	fileType := getFileType(head, file.Filename)
+	if err := checkUploadType(fileType); err != nil {
+		return nil, err
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, src)
-	return err
-}
+	hasher := sha256.New()
+	hasher.Write(head)
+	size, err := io.Copy(hasher, src)
+	if err != nil {
+		return nil, err
+	}
+	size += int64(n)
+	hash := hex.EncodeToString(hasher.Sum(nil))
 
-func getFileType(fileName string) string {
-	file, err := os.Open(fileName)
+	blob, err := reserveBlob(hash, size, fileType)
 	if err != nil {
-		log.Printf("无法打开文件 %s: %v", fileName, err)
-		return "application/octet-stream"
+		return nil, err
+	}
+	if blob.RefCount > 0 {
+		return blob, nil
 	}
-	defer file.Close()
 
-	// 读取前 512 字节用于检测
-	buffer := make([]byte, 512)
-	n, err := file.Read(buffer)
-	if err != nil && err != io.EOF {
-		log.Printf("读取文件头失败: %v", err)
-		return "application/octet-stream"
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
 	}
+	err = infra.GetStorage().Put(context.Background(), blob.StorageKey, src, size, fileType)
+	if err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
 
+func getFileType(head []byte, fileName string) string {
 	// 基于内容检测
-	contentType := http.DetectContentType(buffer[:n])
+	contentType := http.DetectContentType(head)
 
 	// 特殊情况：DetectContentType 对 .txt 返回 application/octet-stream
 	// 可手动修正
@@ -57,3 +77,12 @@ func getFileType(fileName string) string {
 
 	return contentType
 }
+
+// fileKey 把 files.file_url 转换为存储中的 key
+// 早期的记录保存的是本地磁盘的绝对路径，文件名即为 key
+func fileKey(fileURL string) string {
+	if filepath.IsAbs(fileURL) {
+		return filepath.Base(fileURL)
+	}
+	return fileURL
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/pkg/embedding"
+	"github.com/lojes7/inquire/pkg/extract"
+	"github.com/lojes7/inquire/pkg/infra"
+)
+
+const (
+	// defaultFileSearchLimit 未指定时返回的搜索结果数
+	defaultFileSearchLimit = 10
+	// maxFileContentRunes 保存到 file_content 的最大字符数
+	maxFileContentRunes = 100000
+	// fileSnippetRunes 搜索结果中返回的内容摘要长度
+	fileSnippetRunes = 200
+	// embedTimeout 计算一个文件向量的超时时间
+	embedTimeout = time.Minute
+)
+
+// indexFileContent 提取文件文本并计算向量，写入 file_content 和 content_vector
+// 在文件通过安全扫描后异步调用，失败只记录日志，不影响消息本身
+// fileName 为带扩展名的原文件名，用于判断文件类型
+// 相同内容的文件已经提取过时直接复用，不再重复计算
+func indexFileContent(messageID uint64, blob *model.Blob, fileName, fileType string) {
+	if !extract.Supported(fileName, fileType) {
+		return
+	}
+
+	db := infra.GetDB()
+	res := db.Exec(`UPDATE files SET file_content = src.file_content, content_vector = src.content_vector
+			FROM (SELECT file_content, content_vector FROM files
+				WHERE blob_id = ? AND message_id != ? AND content_vector IS NOT NULL
+				LIMIT 1) src
+			WHERE files.message_id = ?`,
+		blob.ID, messageID, messageID)
+	if res.Error != nil {
+		log.Println(res.Error)
+	} else if res.RowsAffected > 0 {
+		return
+	}
+	key := blob.StorageKey
+
+	f, _, err := infra.GetStorage().Get(context.Background(), key)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+
+	text, err := extract.Text(f, fileName, fileType)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	text = extract.Truncate(text, maxFileContentRunes)
+
+	updates := map[string]any{"file_content": text}
+
+	ctx, cancel := context.WithTimeout(context.Background(), embedTimeout)
+	defer cancel()
+	vec, err := infra.GetEmbedder().Embed(ctx, text)
+	if err != nil {
+		if !errors.Is(err, embedding.ErrEmptyText) {
+			log.Println(err)
+		}
+	} else {
+		updates["content_vector"] = model.Vector(vec)
+	}
+
+	res = db.Model(&model.File{}).
+		Where("message_id = ?", messageID).
+		Updates(updates)
+	if res.Error != nil {
+		log.Println(res.Error)
+	}
+}
+
+// SearchFiles 按内容语义搜索文件
+// 只在用户所在的会话中搜索，已撤回、用户自己删除的和没有通过安全扫描的文件不会出现在结果中
+// 结果按与搜索词的余弦距离从近到远排列
+func SearchFiles(userID uint64, req model.SearchFileReq) ([]model.SearchFileResp, error) {
+	db := infra.GetDB()
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultFileSearchLimit
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), embedTimeout)
+	defer cancel()
+	vec, err := infra.GetEmbedder().Embed(ctx, req.Q)
+	if err != nil {
+		if errors.Is(err, embedding.ErrEmptyText) {
+			return []model.SearchFileResp{}, nil
+		}
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+	query := model.Vector(vec)
+
+	sql := `SELECT ` + chatMessageColumns + `,
+			1 - (f.content_vector <=> ?) AS score,
+			LEFT(f.file_content, ?) AS snippet` + chatMessageFrom + `
+			JOIN conversation_users cu ON cu.conversation_id = m.conversation_id AND cu.user_id = ?
+			LEFT JOIN message_users mu ON mu.message_id = m.id AND mu.user_id = ?
+			WHERE m.status = ? AND f.scan_status = ? AND f.content_vector IS NOT NULL AND mu.deleted_at IS NULL`
+	args := append(chatMessageArgs(), query, fileSnippetRunes, userID, userID, model.FILE, model.SCAN_CLEAN)
+
+	if req.ConversationID > 0 {
+		sql += ` AND m.conversation_id = ?`
+		args = append(args, req.ConversationID)
+	}
+	sql += ` ORDER BY f.content_vector <=> ? LIMIT ?`
+	args = append(args, query, limit)
+
+	files := make([]model.SearchFileResp, 0, limit)
+	res := db.Raw(sql, args...).Scan(&files)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return nil, errors.New("服务器错误")
+	}
+
+	return files, nil
+}
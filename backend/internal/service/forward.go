@@ -0,0 +1,535 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/pkg/infra"
+	"github.com/lojes7/inquire/pkg/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// chatRecordPreviewItems 聊天记录消息中预览的条数
+	chatRecordPreviewItems = 3
+	// chatRecordFilePrefix 聊天记录预览中文件的前缀
+	chatRecordFilePrefix = "[文件] "
+)
+
+// forwardSource 被转发的消息
+type forwardSource struct {
+	ID             uint64
+	ConversationID uint64
+	SenderID       uint64
+	SenderName     string
+	Status         uint8
+	CreatedAt      time.Time
+	// 文本消息的内容或聊天记录的标题
+	Text string
+	// 文件消息的文件记录
+	File *model.File `gorm:"-"`
+}
+
+// ForwardMessages 把消息转发到一个或多个会话，返回在每个会话中生成的消息
+// merged 为 false 时逐条转发，为 true 时合并为一条聊天记录消息
+// 文件消息复用原来的文件内容，不重新上传，但和上传一样计入存储配额
+// 所有目标会话在同一个事务中转发，任何一个失败时都不转发
+func ForwardMessages(userID uint64, messageIDs, conversationIDs []uint64, merged bool) ([]model.ForwardResp, error) {
+	messageIDs = uniqueIDs(messageIDs)
+	conversationIDs = uniqueIDs(conversationIDs)
+
+	sources, err := loadForwardSources(userID, messageIDs, merged)
+	if err != nil {
+		return nil, err
+	}
+	size, err := forwardFileSize(sources)
+	if err != nil {
+		return nil, err
+	}
+	for _, conversationID := range conversationIDs {
+		err = sendMessageAuth(userID, conversationID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = checkForwardQuota(userID, conversationIDs, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var title string
+	if merged {
+		title, err = chatRecordTitle(userID, sources[0].ConversationID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	newIDs := make([][]uint64, len(conversationIDs))
+	err = messageTransaction(func(tx *gorm.DB) error {
+		for i, conversationID := range conversationIDs {
+			newIDs[i] = newIDs[i][:0]
+			if merged {
+				newID, err := forwardMerged(tx, userID, conversationID, title, sources)
+				if err != nil {
+					return err
+				}
+				newIDs[i] = append(newIDs[i], newID)
+			} else {
+				for _, src := range sources {
+					newID, err := forwardMessage(tx, userID, conversationID, src)
+					if err != nil {
+						return err
+					}
+					newIDs[i] = append(newIDs[i], newID)
+				}
+			}
+
+			err := updateLastMessageID(tx, conversationID, newIDs[i][len(newIDs[i])-1])
+			if err != nil {
+				return errors.New("服务器错误")
+			}
+			for range newIDs[i] {
+				err = updateUnreadCount(tx, userID, conversationID)
+				if err != nil {
+					return errors.New("服务器错误")
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]model.ForwardResp, 0, len(conversationIDs))
+	for i, conversationID := range conversationIDs {
+		for _, newID := range newIDs[i] {
+			pushNewMessage(newID)
+		}
+		result = append(result, model.ForwardResp{
+			ConversationID: conversationID,
+			MessageIDs:     formatIDs(newIDs[i]),
+		})
+	}
+	return result, nil
+}
+
+// forwardFileSize 转发到一个会话时新增的文件大小，包括转发的聊天记录中的文件
+func forwardFileSize(sources []forwardSource) (int64, error) {
+	var size int64
+	var recordIDs []uint64
+	for _, src := range sources {
+		switch {
+		case src.File != nil:
+			size += src.File.FileSize
+		case src.Status == model.CHAT_RECORD:
+			recordIDs = append(recordIDs, src.ID)
+		}
+	}
+	if len(recordIDs) == 0 {
+		return size, nil
+	}
+
+	var recordSize int64
+	err := infra.GetDB().Model(&model.ChatRecordItem{}).
+		Select("COALESCE(SUM(file_size), 0)").
+		Where("record_id IN ? AND status = ?", recordIDs, model.FILE).
+		Scan(&recordSize).Error
+	if err != nil {
+		log.Println(err)
+		return 0, errors.New("服务器错误")
+	}
+	return size + recordSize, nil
+}
+
+// loadForwardSources 查询被转发的消息，按消息ID从旧到新排列
+// 用户必须在消息所在的会话中，已撤回的消息、系统消息和未通过安全扫描的文件不能转发
+func loadForwardSources(userID uint64, messageIDs []uint64, merged bool) ([]forwardSource, error) {
+	db := infra.GetDB()
+	var sources []forwardSource
+	err := db.Table("messages m").
+		Select("m.id, m.conversation_id, m.sender_id, u.name AS sender_name, m.status, m.created_at, t.text").
+		Joins("LEFT JOIN users u ON u.id = m.sender_id").
+		Joins("LEFT JOIN texts t ON t.message_id = m.id").
+		// 自己删除或清空过的消息看不到，也不能转发
+		Joins("LEFT JOIN message_users mu ON mu.message_id = m.id AND mu.user_id = ?", userID).
+		Where("m.id IN ? AND m.deleted_at IS NULL AND mu.deleted_at IS NULL", messageIDs).
+		Order("m.id ASC").
+		Scan(&sources).Error
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+	if len(sources) != len(messageIDs) {
+		return nil, errors.New("消息不存在")
+	}
+
+	var fileMessageIDs []uint64
+	checked := make(map[uint64]bool)
+	for _, src := range sources {
+		switch src.Status {
+		case model.RECALLED:
+			return nil, errors.New("不能转发已撤回的消息")
+		case model.SYSTEM:
+			return nil, errors.New("不能转发系统消息")
+		case model.FILE:
+			fileMessageIDs = append(fileMessageIDs, src.ID)
+		case model.CHAT_RECORD:
+			if merged {
+				return nil, errors.New("聊天记录不能再合并转发")
+			}
+		}
+		if merged && src.ConversationID != sources[0].ConversationID {
+			return nil, errors.New("合并转发的消息必须来自同一个会话")
+		}
+
+		if !checked[src.ConversationID] {
+			checked[src.ConversationID] = true
+			err = sendMessageAuth(userID, src.ConversationID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(fileMessageIDs) == 0 {
+		return sources, nil
+	}
+	var files []model.File
+	err = db.Where("message_id IN ?", fileMessageIDs).Find(&files).Error
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+	fileByMessage := make(map[uint64]*model.File, len(files))
+	for i := range files {
+		fileByMessage[files[i].MessageID] = &files[i]
+	}
+	for i := range sources {
+		if sources[i].Status != model.FILE {
+			continue
+		}
+		file, ok := fileByMessage[sources[i].ID]
+		if !ok {
+			return nil, errors.New("文件不存在")
+		}
+		// 逐条转发的文件复制扫描状态，原文件扫描完成时会一起更新
+		// 聊天记录中的文件不再单独扫描，只能转发已通过的文件
+		if file.ScanStatus == model.SCAN_INFECTED || file.ScanStatus == model.SCAN_FAILED ||
+			(merged && file.ScanStatus != model.SCAN_CLEAN) {
+			return nil, checkScanStatus(file.ScanStatus)
+		}
+		sources[i].File = file
+	}
+	return sources, nil
+}
+
+// forwardMessage 在目标会话中创建一条与原消息内容相同的消息
+func forwardMessage(tx *gorm.DB, userID, conversationID uint64, src forwardSource) (uint64, error) {
+	newID := utils.NewUniqueID()
+	res := tx.Create(&model.Message{
+		SenderID:       userID,
+		ConversationID: conversationID,
+		Status:         src.Status,
+		MyModel: model.MyModel{
+			ID: newID,
+		},
+	})
+	if res.Error != nil {
+		log.Println(res.Error)
+		return 0, errors.New("服务器错误")
+	}
+
+	switch src.Status {
+	case model.FILE:
+		newFile := *src.File
+		newFile.MyModel = model.MyModel{}
+		newFile.MessageID = newID
+		res = tx.Create(&newFile)
+		if res.Error != nil {
+			log.Println(res.Error)
+			return 0, errors.New("服务器错误")
+		}
+		if newFile.BlobID != 0 {
+			err := acquireBlob(tx, newFile.BlobID)
+			if err != nil {
+				return 0, err
+			}
+		}
+	case model.TEXT, model.CHAT_RECORD:
+		res = tx.Create(&model.Text{
+			Text:      src.Text,
+			MessageID: newID,
+		})
+		if res.Error != nil {
+			log.Println(res.Error)
+			return 0, errors.New("服务器错误")
+		}
+	}
+
+	if src.Status == model.CHAT_RECORD {
+		var items []model.ChatRecordItem
+		err := tx.Where("record_id = ?", src.ID).Order("id ASC").Find(&items).Error
+		if err != nil {
+			log.Println(err)
+			return 0, errors.New("服务器错误")
+		}
+		for i := range items {
+			items[i].MyModel = model.MyModel{}
+			items[i].RecordID = newID
+		}
+		err = createChatRecordItems(tx, items)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return newID, nil
+}
+
+// forwardMerged 在目标会话中创建一条聊天记录消息
+func forwardMerged(tx *gorm.DB, userID, conversationID uint64, title string, sources []forwardSource) (uint64, error) {
+	newID := utils.NewUniqueID()
+	res := tx.Create(&model.Message{
+		SenderID:       userID,
+		ConversationID: conversationID,
+		Status:         model.CHAT_RECORD,
+		MyModel: model.MyModel{
+			ID: newID,
+		},
+	})
+	if res.Error != nil {
+		log.Println(res.Error)
+		return 0, errors.New("服务器错误")
+	}
+	res = tx.Create(&model.Text{
+		Text:      title,
+		MessageID: newID,
+	})
+	if res.Error != nil {
+		log.Println(res.Error)
+		return 0, errors.New("服务器错误")
+	}
+
+	items := make([]model.ChatRecordItem, 0, len(sources))
+	for _, src := range sources {
+		item := model.ChatRecordItem{
+			RecordID:        newID,
+			SourceMessageID: src.ID,
+			SenderID:        src.SenderID,
+			SenderName:      src.SenderName,
+			SentAt:          src.CreatedAt,
+			Status:          src.Status,
+			Text:            src.Text,
+		}
+		if src.File != nil {
+			item.Text = ""
+			item.FileName = src.File.FileName
+			item.FileExt = src.File.FileExt
+			item.FileType = src.File.FileType
+			item.FileURL = src.File.FileURL
+			item.FileSize = src.File.FileSize
+			item.BlobID = src.File.BlobID
+		}
+		items = append(items, item)
+	}
+	err := createChatRecordItems(tx, items)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// createChatRecordItems 保存聊天记录中的消息，其中的文件各增加一次 Blob 的引用
+func createChatRecordItems(tx *gorm.DB, items []model.ChatRecordItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	res := tx.Create(&items)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return errors.New("服务器错误")
+	}
+	for _, item := range items {
+		if item.BlobID == 0 {
+			continue
+		}
+		err := acquireBlob(tx, item.BlobID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseChatRecordBlobs 释放聊天记录中的文件对 Blob 的引用
+func releaseChatRecordBlobs(tx *gorm.DB, messageID uint64) error {
+	var blobIDs []uint64
+	err := tx.Model(&model.ChatRecordItem{}).
+		Where("record_id = ? AND blob_id != 0", messageID).
+		Pluck("blob_id", &blobIDs).Error
+	if err != nil {
+		log.Println(err)
+		return errors.New("服务器错误")
+	}
+	for _, blobID := range blobIDs {
+		err = releaseBlob(tx, blobID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chatRecordTitle 聊天记录的标题
+// 群聊为“群名的聊天记录”，私聊为“我和对方的聊天记录”
+func chatRecordTitle(userID, conversationID uint64) (string, error) {
+	db := infra.GetDB()
+	var conversation model.Conversation
+	res := db.Select("type, name").
+		Where("id = ?", conversationID).
+		Limit(1).
+		Find(&conversation)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return "", errors.New("服务器错误")
+	}
+	if res.RowsAffected > 0 && conversation.Type == model.GROUP {
+		if conversation.Name == "" {
+			return "群聊的聊天记录", nil
+		}
+		return conversation.Name + "的聊天记录", nil
+	}
+
+	// 私聊没有 conversations 记录，标题为双方的名字
+	var members []struct {
+		UserID uint64
+		Name   string
+	}
+	err := db.Table("conversation_users cu").
+		Select("cu.user_id, u.name").
+		Joins("JOIN users u ON u.id = cu.user_id").
+		Where("cu.conversation_id = ?", conversationID).
+		Order("cu.user_id").
+		Scan(&members).Error
+	if err != nil {
+		log.Println(err)
+		return "", errors.New("服务器错误")
+	}
+	names := make([]string, 0, len(members))
+	for _, member := range members {
+		if member.UserID == userID {
+			names = append([]string{member.Name}, names...)
+		} else {
+			names = append(names, member.Name)
+		}
+	}
+	return strings.Join(names, "和") + "的聊天记录", nil
+}
+
+// ChatRecord 查看聊天记录消息的内容，只有会话成员可以查看
+func ChatRecord(userID, messageID uint64) (*model.ChatRecordResp, error) {
+	db := infra.GetDB()
+	var title string
+	res := db.Table("messages m").
+		Select("t.text").
+		Joins("JOIN conversation_users cu ON cu.conversation_id = m.conversation_id").
+		Joins("JOIN texts t ON t.message_id = m.id").
+		Where("m.id = ? AND cu.user_id = ? AND m.status = ?", messageID, userID, model.CHAT_RECORD).
+		Limit(1).
+		Scan(&title)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return nil, errors.New("服务器错误")
+	}
+	if res.RowsAffected == 0 {
+		return nil, errors.New("聊天记录不存在或无访问权限")
+	}
+
+	var items []model.ChatRecordItem
+	err := db.Where("record_id = ?", messageID).Order("id ASC").Find(&items).Error
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+
+	resp := &model.ChatRecordResp{
+		Title: title,
+		Items: make([]model.ChatRecordItemResp, 0, len(items)),
+	}
+	for _, item := range items {
+		var content any = item.Text
+		if item.Status == model.FILE {
+			content = map[string]any{
+				"file_name":    item.FileName,
+				"file_url":     item.FileURL,
+				"file_size":    item.FileSize,
+				"file_type":    item.FileType,
+				"download_url": "/api/auth/files/" + strconv.FormatUint(messageID, 10) + "/items/" + strconv.FormatUint(item.ID, 10),
+			}
+		}
+		contentBytes, err := json.Marshal(content)
+		if err != nil {
+			log.Println(err)
+			return nil, errors.New("服务器错误")
+		}
+		resp.Items = append(resp.Items, model.ChatRecordItemResp{
+			ItemID:     item.ID,
+			SenderID:   item.SenderID,
+			SenderName: item.SenderName,
+			SentAt:     item.SentAt,
+			Status:     item.Status,
+			Content:    contentBytes,
+		})
+	}
+	return resp, nil
+}
+
+// DownloadChatRecordFile 校验用户是否可以下载聊天记录中的文件，返回与 DownloadFile 相同的文件记录
+func DownloadChatRecordFile(userID, messageID, itemID uint64) (*model.File, error) {
+	db := infra.GetDB()
+	var item model.ChatRecordItem
+	err := db.Model(&model.ChatRecordItem{}).
+		Select("chat_record_items.file_name, chat_record_items.file_ext, chat_record_items.file_type, chat_record_items.file_url, chat_record_items.file_size, chat_record_items.created_at").
+		Joins("JOIN messages m ON m.id = chat_record_items.record_id").
+		Joins("JOIN conversation_users cu ON cu.conversation_id = m.conversation_id").
+		Where("chat_record_items.id = ? AND chat_record_items.record_id = ? AND chat_record_items.status = ? AND cu.user_id = ? AND m.status = ?",
+			itemID, messageID, model.FILE, userID, model.CHAT_RECORD).
+		Take(&item).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("文件不存在或无访问权限")
+		}
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+
+	// 聊天记录中的文件在转发时已经通过了安全扫描
+	return &model.File{
+		MyModel:   model.MyModel{ID: item.ID, CreatedAt: item.CreatedAt},
+		FileName:  item.FileName,
+		FileExt:   item.FileExt,
+		FileType:  item.FileType,
+		FileURL:   fileKey(item.FileURL),
+		FileSize:  item.FileSize,
+		MessageID: item.ID,
+	}, nil
+}
+
+// uniqueIDs 去掉重复的ID，保持原来的顺序
+func uniqueIDs(ids []uint64) []uint64 {
+	seen := make(map[uint64]bool, len(ids))
+	result := make([]uint64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -47,7 +47,7 @@ func SendFriendRequest(senderID, receiverID uint64, msg string, senderName strin
 	if err == nil {
 		// 发送 websocket 通知
 		notification := map[string]any{
-			"type": "new_friend_request",
+			"type": ws.EventNewFriendRequest,
 			"data": map[string]any{
 				"sender_id":   senderID,
 				"sender_name": senderName,
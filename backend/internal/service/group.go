@@ -0,0 +1,484 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/pkg/infra"
+	"github.com/lojes7/inquire/pkg/utils"
+	"gorm.io/gorm"
+)
+
+// CreateGroupConversation 创建群聊
+// memberIDs 为群主以外的初始成员，必须都是群主的好友
+// 最后返回群聊的会话ID
+func CreateGroupConversation(ownerID uint64, name string, memberIDs []uint64) (uint64, error) {
+	memberIDs = uniqueMemberIDs(ownerID, memberIDs)
+	if len(memberIDs) < 2 {
+		return 0, errors.New("群聊至少需要三人")
+	}
+
+	for _, memberID := range memberIDs {
+		ok, err := isFriend(ownerID, memberID)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, errors.New("只能邀请好友加入群聊")
+		}
+	}
+
+	conversation := model.Conversation{
+		MyModel: model.MyModel{
+			ID: utils.NewUniqueID(),
+		},
+		Type:    model.GROUP,
+		Name:    name,
+		OwnerID: ownerID,
+	}
+
+	var newID uint64
+	err := messageTransaction(func(tx *gorm.DB) error {
+		newID = utils.NewUniqueID()
+		res := tx.Create(&conversation)
+		if res.Error != nil {
+			log.Println(res.Error)
+			return errors.New("服务器错误")
+		}
+
+		// 群聊在聊天列表中默认显示群名
+		err := CreateConversationUser(tx, ownerID, conversation.ID, name)
+		if err != nil {
+			return err
+		}
+		err = setMemberRole(tx, conversation.ID, ownerID, model.OWNER)
+		if err != nil {
+			return err
+		}
+		for _, memberID := range memberIDs {
+			err = CreateConversationUser(tx, memberID, conversation.ID, name)
+			if err != nil {
+				return err
+			}
+		}
+
+		ownerName, err := getUserName(tx, ownerID)
+		if err != nil {
+			return err
+		}
+		memberNames, err := getUserNames(tx, memberIDs)
+		if err != nil {
+			return err
+		}
+
+		newContent := ownerName + "邀请" + strings.Join(memberNames, "、") + "加入了群聊"
+		err = createSystemMessage(tx, newContent, conversation.ID, newID)
+		if err != nil {
+			return err
+		}
+
+		return updateLastMessageID(tx, conversation.ID, newID)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	pushNewMessage(newID)
+	return conversation.ID, nil
+}
+
+// uniqueMemberIDs 对成员ID去重，并去掉群主自己
+func uniqueMemberIDs(ownerID uint64, memberIDs []uint64) []uint64 {
+	seen := make(map[uint64]struct{}, len(memberIDs))
+	result := make([]uint64, 0, len(memberIDs))
+	for _, id := range memberIDs {
+		if id == ownerID {
+			continue
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		result = append(result, id)
+	}
+	return result
+}
+
+// getUserName 查询单个用户的用户名
+func getUserName(tx *gorm.DB, userID uint64) (string, error) {
+	var name string
+	err := tx.Model(&model.User{}).
+		Where("id = ?", userID).
+		Pluck("name", &name).Error
+	if err != nil {
+		log.Println(err)
+		return "", errors.New("服务器错误")
+	}
+	return name, nil
+}
+
+// getUserNames 批量查询用户名，按 userIDs 的顺序返回
+func getUserNames(tx *gorm.DB, userIDs []uint64) ([]string, error) {
+	var users []model.User
+	err := tx.Select("id, name").
+		Where("id IN ?", userIDs).
+		Find(&users).Error
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+
+	nameByID := make(map[uint64]string, len(users))
+	for _, u := range users {
+		nameByID[u.ID] = u.Name
+	}
+	names := make([]string, 0, len(userIDs))
+	for _, id := range userIDs {
+		if name, ok := nameByID[id]; ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// InviteGroupMembers 邀请好友加入群聊
+// 群内任意成员都可以邀请自己的好友，返回生成的系统消息ID
+func InviteGroupMembers(inviterID, conversationID uint64, memberIDs []uint64) (uint64, error) {
+	if _, err := getGroupMember(infra.GetDB(), conversationID, inviterID); err != nil {
+		return 0, err
+	}
+
+	memberIDs = uniqueMemberIDs(inviterID, memberIDs)
+	for _, memberID := range memberIDs {
+		ok, err := isFriend(inviterID, memberID)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, errors.New("只能邀请好友加入群聊")
+		}
+	}
+
+	var newID uint64
+	err := messageTransaction(func(tx *gorm.DB) error {
+		newID = utils.NewUniqueID()
+		groupName, err := getGroupName(tx, conversationID)
+		if err != nil {
+			return err
+		}
+
+		// 过滤掉已经在群里的成员
+		var existing []uint64
+		err = tx.Unscoped().Model(&model.ConversationUser{}).
+			Where("conversation_id = ? AND user_id IN ?", conversationID, memberIDs).
+			Pluck("user_id", &existing).Error
+		if err != nil {
+			log.Println(err)
+			return errors.New("服务器错误")
+		}
+		joined := make(map[uint64]struct{}, len(existing))
+		for _, id := range existing {
+			joined[id] = struct{}{}
+		}
+
+		newMembers := make([]uint64, 0, len(memberIDs))
+		for _, memberID := range memberIDs {
+			if _, ok := joined[memberID]; ok {
+				continue
+			}
+			err = CreateConversationUser(tx, memberID, conversationID, groupName)
+			if err != nil {
+				return err
+			}
+			newMembers = append(newMembers, memberID)
+		}
+		if len(newMembers) == 0 {
+			return errors.New("被邀请的用户都已在群聊中")
+		}
+
+		inviterName, err := getUserName(tx, inviterID)
+		if err != nil {
+			return err
+		}
+		memberNames, err := getUserNames(tx, newMembers)
+		if err != nil {
+			return err
+		}
+		newContent := inviterName + "邀请" + strings.Join(memberNames, "、") + "加入了群聊"
+		return createMemberSystemMessage(tx, newContent, conversationID, newID)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	pushNewMessage(newID)
+	return newID, nil
+}
+
+// RemoveGroupMember 将成员移出群聊
+// 群主可以移除任何人，管理员只能移除普通成员，返回生成的系统消息ID
+func RemoveGroupMember(operatorID, conversationID, memberID uint64) (uint64, error) {
+	if operatorID == memberID {
+		return 0, errors.New("不能将自己移出群聊")
+	}
+
+	var newID uint64
+	err := messageTransaction(func(tx *gorm.DB) error {
+		newID = utils.NewUniqueID()
+		operator, err := getGroupMember(tx, conversationID, operatorID)
+		if err != nil {
+			return err
+		}
+		member, err := getGroupMember(tx, conversationID, memberID)
+		if err != nil {
+			return err
+		}
+		if operator.Role <= member.Role || operator.Role == model.MEMBER {
+			return errors.New("没有权限移除该成员")
+		}
+
+		err = deleteGroupMember(tx, conversationID, memberID)
+		if err != nil {
+			return err
+		}
+
+		operatorName, err := getUserName(tx, operatorID)
+		if err != nil {
+			return err
+		}
+		memberName, err := getUserName(tx, memberID)
+		if err != nil {
+			return err
+		}
+		newContent := operatorName + "将" + memberName + "移出了群聊"
+		return createMemberSystemMessage(tx, newContent, conversationID, newID)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	pushNewMessage(newID, memberID)
+	return newID, nil
+}
+
+// LeaveGroup 退出群聊
+// 群主需要先转让群主身份才能退出，返回生成的系统消息ID
+func LeaveGroup(userID, conversationID uint64) (uint64, error) {
+	var newID uint64
+	err := messageTransaction(func(tx *gorm.DB) error {
+		newID = utils.NewUniqueID()
+		member, err := getGroupMember(tx, conversationID, userID)
+		if err != nil {
+			return err
+		}
+		if member.Role == model.OWNER {
+			return errors.New("群主需要先转让群主身份才能退出群聊")
+		}
+
+		err = deleteGroupMember(tx, conversationID, userID)
+		if err != nil {
+			return err
+		}
+
+		userName, err := getUserName(tx, userID)
+		if err != nil {
+			return err
+		}
+		newContent := userName + "退出了群聊"
+		return createMemberSystemMessage(tx, newContent, conversationID, newID)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	pushNewMessage(newID)
+	return newID, nil
+}
+
+// TransferGroupOwner 转让群主
+// 原群主转让后成为普通成员，返回生成的系统消息ID
+func TransferGroupOwner(ownerID, conversationID, newOwnerID uint64) (uint64, error) {
+	if ownerID == newOwnerID {
+		return 0, errors.New("不能将群主转让给自己")
+	}
+
+	var newID uint64
+	err := messageTransaction(func(tx *gorm.DB) error {
+		newID = utils.NewUniqueID()
+		owner, err := getGroupMember(tx, conversationID, ownerID)
+		if err != nil {
+			return err
+		}
+		if owner.Role != model.OWNER {
+			return errors.New("只有群主才能转让群主")
+		}
+		if _, err = getGroupMember(tx, conversationID, newOwnerID); err != nil {
+			return err
+		}
+
+		err = setMemberRole(tx, conversationID, ownerID, model.MEMBER)
+		if err != nil {
+			return err
+		}
+		err = setMemberRole(tx, conversationID, newOwnerID, model.OWNER)
+		if err != nil {
+			return err
+		}
+		res := tx.Model(&model.Conversation{}).
+			Where("id = ?", conversationID).
+			Update("owner_id", newOwnerID)
+		if res.Error != nil {
+			log.Println(res.Error)
+			return errors.New("服务器错误")
+		}
+
+		ownerName, err := getUserName(tx, ownerID)
+		if err != nil {
+			return err
+		}
+		newOwnerName, err := getUserName(tx, newOwnerID)
+		if err != nil {
+			return err
+		}
+		newContent := ownerName + "将群主转让给了" + newOwnerName
+		return createMemberSystemMessage(tx, newContent, conversationID, newID)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	pushNewMessage(newID)
+	return newID, nil
+}
+
+// SetGroupAdmin 设置或取消群管理员
+// 只有群主可以操作，返回生成的系统消息ID
+func SetGroupAdmin(ownerID, conversationID, memberID uint64, isAdmin bool) (uint64, error) {
+	var newID uint64
+	err := messageTransaction(func(tx *gorm.DB) error {
+		newID = utils.NewUniqueID()
+		owner, err := getGroupMember(tx, conversationID, ownerID)
+		if err != nil {
+			return err
+		}
+		if owner.Role != model.OWNER {
+			return errors.New("只有群主才能设置管理员")
+		}
+		member, err := getGroupMember(tx, conversationID, memberID)
+		if err != nil {
+			return err
+		}
+		if member.Role == model.OWNER {
+			return errors.New("不能修改群主的身份")
+		}
+
+		role := model.MEMBER
+		if isAdmin {
+			role = model.ADMIN
+		}
+		if member.Role == role {
+			return errors.New("该成员已经是该身份")
+		}
+		err = setMemberRole(tx, conversationID, memberID, role)
+		if err != nil {
+			return err
+		}
+
+		ownerName, err := getUserName(tx, ownerID)
+		if err != nil {
+			return err
+		}
+		memberName, err := getUserName(tx, memberID)
+		if err != nil {
+			return err
+		}
+		newContent := ownerName + "将" + memberName + "设为了管理员"
+		if !isAdmin {
+			newContent = ownerName + "取消了" + memberName + "的管理员身份"
+		}
+		return createMemberSystemMessage(tx, newContent, conversationID, newID)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	pushNewMessage(newID)
+	return newID, nil
+}
+
+// getGroupMember 查询群聊中的某个成员
+// 隐藏了会话的成员仍然算作群成员，会话不是群聊或用户不在群聊中时返回错误
+func getGroupMember(tx *gorm.DB, conversationID, userID uint64) (*model.ConversationUser, error) {
+	var member model.ConversationUser
+	err := tx.Unscoped().Model(&model.ConversationUser{}).
+		Select("conversation_users.user_id, conversation_users.role").
+		Joins("JOIN conversations c ON c.id = conversation_users.conversation_id").
+		Where("conversation_users.conversation_id = ? AND conversation_users.user_id = ? AND c.type = ?",
+			conversationID, userID, model.GROUP).
+		First(&member).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("群聊不存在或用户不在群聊中")
+		}
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+	return &member, nil
+}
+
+// getGroupName 查询群名
+func getGroupName(tx *gorm.DB, conversationID uint64) (string, error) {
+	var name string
+	err := tx.Model(&model.Conversation{}).
+		Where("id = ?", conversationID).
+		Pluck("name", &name).Error
+	if err != nil {
+		log.Println(err)
+		return "", errors.New("服务器错误")
+	}
+	return name, nil
+}
+
+// setMemberRole 修改群成员的角色
+func setMemberRole(tx *gorm.DB, conversationID, userID uint64, role uint8) error {
+	res := tx.Unscoped().Model(&model.ConversationUser{}).
+		Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		Update("role", role)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return errors.New("服务器错误")
+	}
+	if res.RowsAffected == 0 {
+		log.Println("修改群成员角色操作影响了0行表")
+		return errors.New("服务器错误")
+	}
+	return nil
+}
+
+// deleteGroupMember 将用户从群聊中彻底移除
+// 与隐藏会话的软删除不同，这里直接删除 conversation_users 记录
+func deleteGroupMember(tx *gorm.DB, conversationID, userID uint64) error {
+	res := tx.Unscoped().
+		Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		Delete(&model.ConversationUser{})
+	if res.Error != nil {
+		log.Println(res.Error)
+		return errors.New("服务器错误")
+	}
+	if res.RowsAffected == 0 {
+		log.Println("删除群成员操作影响了0行表")
+		return errors.New("服务器错误")
+	}
+	return nil
+}
+
+// createMemberSystemMessage 为群成员变动创建系统消息并更新 last_message_id
+func createMemberSystemMessage(tx *gorm.DB, content string, conversationID, newID uint64) error {
+	err := createSystemMessage(tx, content, conversationID, newID)
+	if err != nil {
+		return err
+	}
+	return updateLastMessageID(tx, conversationID, newID)
+}
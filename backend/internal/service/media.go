@@ -0,0 +1,184 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/internal/ws"
+	"github.com/lojes7/inquire/pkg/infra"
+	"github.com/lojes7/inquire/pkg/media"
+	"github.com/lojes7/inquire/pkg/storage"
+	"gorm.io/gorm"
+)
+
+// thumbnailSizes 缩略图的规格，值为最长边的像素数
+var thumbnailSizes = map[string]int{
+	"small": 200,
+	"large": 800,
+}
+
+// defaultThumbnailSize 未指定时返回的缩略图规格
+const defaultThumbnailSize = "small"
+
+// mediaWorkers 同时处理媒体信息的文件数，解码大图片占用的内存较多
+const mediaWorkers = 2
+
+// mediaSlots 限制同时处理的数量，超出的排队等待
+var mediaSlots = make(chan struct{}, mediaWorkers)
+
+// thumbnailKey 缩略图在存储中的 key
+func thumbnailKey(hash, size string) string {
+	return "thumbs/" + hash[:2] + "/" + hash + "_" + size + ".jpg"
+}
+
+// thumbnailURL 文件消息的缩略图地址
+func thumbnailURL(messageID uint64) string {
+	return "/api/auth/files/" + strconv.FormatUint(messageID, 10) + "/thumbnail"
+}
+
+// processBlobMedia 读取文件内容的媒体信息并生成缩略图，结果写回 blob，并推送给引用该内容的文件所在的会话
+// 在文件消息发送成功后异步调用，同时最多处理 mediaWorkers 个
+// 相同内容只处理一次，失败只记录日志，不影响文件的发送
+func processBlobMedia(blob *model.Blob) {
+	if blob.MediaStatus != model.MEDIA_PENDING {
+		return
+	}
+	mediaSlots <- struct{}{}
+	defer func() { <-mediaSlots }()
+
+	ctx := context.Background()
+	content := storage.NewReadSeeker(ctx, infra.GetStorage(), blob.StorageKey, blob.Size)
+	defer content.Close()
+
+	status := model.MEDIA_DONE
+	info, err := media.Probe(content, blob.Size, blob.ContentType)
+	if err != nil {
+		log.Println(err)
+		status = model.MEDIA_FAILED
+		info = &media.Info{}
+	}
+
+	hasThumbnail := false
+	if info.Image != nil {
+		hasThumbnail = true
+		for name, side := range thumbnailSizes {
+			thumb, err := media.Thumbnail(info.Image, side)
+			if err == nil {
+				err = infra.GetStorage().Put(ctx, thumbnailKey(blob.Hash, name),
+					bytes.NewReader(thumb), int64(len(thumb)), "image/jpeg")
+			}
+			if err != nil {
+				log.Println(err)
+				hasThumbnail = false
+				status = model.MEDIA_FAILED
+				break
+			}
+		}
+	}
+
+	db := infra.GetDB()
+	res := db.Model(&model.Blob{}).
+		Where("id = ?", blob.ID).
+		Updates(map[string]any{
+			"media_status":  status,
+			"width":         info.Width,
+			"height":        info.Height,
+			"page_count":    info.PageCount,
+			"duration_ms":   info.DurationMs,
+			"has_thumbnail": hasThumbnail,
+		})
+	if res.Error != nil {
+		log.Println(res.Error)
+		return
+	}
+	if info.Width == nil && info.Height == nil && info.PageCount == nil && info.DurationMs == nil {
+		return
+	}
+
+	// 处理完成前发送的文件消息不带媒体信息，这里补推
+	var files []struct {
+		ConversationID uint64
+		MessageID      uint64
+		ScanStatus     uint8
+	}
+	err = db.Table("files f").
+		Select("m.conversation_id, f.message_id, f.scan_status").
+		Joins("JOIN messages m ON m.id = f.message_id").
+		Where("f.blob_id = ? AND m.status = ?", blob.ID, model.FILE).
+		Scan(&files).Error
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, file := range files {
+		event := model.FileMediaEventResp{
+			ConversationID: file.ConversationID,
+			MessageID:      file.MessageID,
+			Width:          info.Width,
+			Height:         info.Height,
+			PageCount:      info.PageCount,
+			DurationMs:     info.DurationMs,
+		}
+		// 与消息 content 一样，通过扫描后才带上缩略图
+		if hasThumbnail && file.ScanStatus == model.SCAN_CLEAN {
+			event.ThumbnailURL = thumbnailURL(file.MessageID)
+		}
+		pushToConversation(file.ConversationID, ws.EventFileMedia, event)
+	}
+}
+
+// deleteThumbnails 删除 blob 的所有缩略图
+func deleteThumbnails(blob *model.Blob) {
+	for name := range thumbnailSizes {
+		if err := infra.GetStorage().Delete(context.Background(), thumbnailKey(blob.Hash, name)); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// FileThumbnail 校验用户是否可以查看该文件，返回缩略图的 key 和文件的发送时间
+func FileThumbnail(userID, messageID uint64, size string) (string, time.Time, error) {
+	if size == "" {
+		size = defaultThumbnailSize
+	}
+	if _, ok := thumbnailSizes[size]; !ok {
+		return "", time.Time{}, errors.New("缩略图规格错误")
+	}
+
+	db := infra.GetDB()
+	var result struct {
+		Hash         string
+		HasThumbnail bool
+		ScanStatus   uint8
+		CreatedAt    time.Time
+	}
+	err := db.Model(&model.File{}).
+		Select("b.hash, b.has_thumbnail, files.scan_status, files.created_at").
+		Joins("JOIN messages m ON m.id = files.message_id").
+		Joins("JOIN conversation_users cu ON cu.conversation_id = m.conversation_id").
+		Joins("JOIN blobs b ON b.id = files.blob_id").
+		Where("files.message_id = ? AND cu.user_id = ? AND m.status = ?",
+			messageID, userID, model.FILE).
+		Take(&result).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", time.Time{}, errors.New("文件不存在或无访问权限")
+		}
+		log.Println(err)
+		return "", time.Time{}, errors.New("服务器错误")
+	}
+	err = checkScanStatus(result.ScanStatus)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !result.HasThumbnail {
+		return "", time.Time{}, errors.New("该文件没有缩略图")
+	}
+
+	return thumbnailKey(result.Hash, size), result.CreatedAt, nil
+}
@@ -1,25 +1,29 @@
 package service
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"log"
 	"mime/multipart"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/internal/ws"
 	"github.com/lojes7/inquire/pkg/infra"
 	"github.com/lojes7/inquire/pkg/utils"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // sendMessageAuth 验证用户是否有权限在该会话中发送消息
 func sendMessageAuth(userID, conversationID uint64) error {
 	// 检查 conversation_users 表中是否存在该用户和会话
+	// 隐藏了会话的用户仍然是会话成员
 	var cnt int64
 	db := infra.GetDB()
-	err := db.Model(&model.ConversationUser{}).
+	err := db.Unscoped().Model(&model.ConversationUser{}).
 		Where("user_id = ? AND conversation_id = ?", userID, conversationID).
 		Count(&cnt).Error
 	if err != nil {
@@ -32,6 +36,36 @@ func sendMessageAuth(userID, conversationID uint64) error {
 	return nil
 }
 
+// checkReplyTo 检查被回复的消息是否在同一个会话中，已撤回的消息和系统消息不能被回复
+func checkReplyTo(conversationID, replyToID uint64) error {
+	if replyToID == 0 {
+		return nil
+	}
+
+	var msg model.Message
+	err := infra.GetDB().
+		Select("conversation_id, status").
+		Where("id = ?", replyToID).
+		Take(&msg).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("被回复的消息不存在")
+		}
+		log.Println(err)
+		return errors.New("服务器错误")
+	}
+	if msg.ConversationID != conversationID {
+		return errors.New("被回复的消息不存在")
+	}
+	switch msg.Status {
+	case model.RECALLED:
+		return errors.New("不能回复已撤回的消息")
+	case model.SYSTEM:
+		return errors.New("不能回复系统消息")
+	}
+	return nil
+}
+
 // createSystemMessage 在一个会话中创建一个系统级消息
 // newID用户指定该系统消息的ID
 func createSystemMessage(tx *gorm.DB, content string, conversationID, newID uint64) error {
@@ -64,7 +98,7 @@ func createSystemMessage(tx *gorm.DB, content string, conversationID, newID uint
 
 // updateUnreadCount 给当前会话中除开当前sender的所有人的unread_count加一
 func updateUnreadCount(tx *gorm.DB, senderID, conversationID uint64) error {
-	res := tx.Model(&model.ConversationUser{}).
+	res := tx.Unscoped().Model(&model.ConversationUser{}).
 		Where("user_id != ? AND conversation_id = ?",
 			senderID, conversationID).
 		UpdateColumn("unread_count", gorm.Expr("unread_count + ?", 1))
@@ -80,10 +114,11 @@ func updateUnreadCount(tx *gorm.DB, senderID, conversationID uint64) error {
 }
 
 // updateLastMessageID 更新当前会话的last_message_id
+// 有新消息时隐藏了该会话的成员会重新看到该会话
 func updateLastMessageID(tx *gorm.DB, conversationID, msgID uint64) error {
-	res := tx.Model(&model.ConversationUser{}).
+	res := tx.Unscoped().Model(&model.ConversationUser{}).
 		Where("conversation_id = ?", conversationID).
-		Update("last_message_id", msgID)
+		Updates(map[string]any{"last_message_id": msgID, "deleted_at": nil})
 	if res.Error != nil {
 		log.Println(res.Error)
 		return res.Error
@@ -95,27 +130,68 @@ func updateLastMessageID(tx *gorm.DB, conversationID, msgID uint64) error {
 	return nil
 }
 
-func SendText(senderID, conversationID uint64, content string) (uint64, error) {
-	err := sendMessageAuth(senderID, conversationID)
+// messageTxTimeout 写入消息的事务最长的执行时间，超时的事务会被取消并回滚
+const messageTxTimeout = 10 * time.Second
+
+// messageCommitMargin 在事务超时之外额外留出的时间，覆盖提交本身的耗时
+const messageCommitMargin = 2 * time.Second
+
+// messageTransaction 在限时的事务中写入消息，消息ID必须在 fn 中生成
+// 这样ID对应的时间之后超过 messageTxTimeout 还没有提交的消息就不会再出现，见 stableMessageID
+func messageTransaction(fn func(tx *gorm.DB) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), messageTxTimeout)
+	defer cancel()
+	return infra.GetDB().WithContext(ctx).Transaction(fn)
+}
+
+// transactionTime 返回数据库中当前事务开始的时间（NOW()）
+// 离线同步按时间窗口查找的变化用它作为时间，需要在 messageTransaction 中调用，
+// 事务限时提交，这个时间之后超过 messageTxTimeout 就不会再出现新的变化
+func transactionTime(tx *gorm.DB) (time.Time, error) {
+	var now time.Time
+	err := tx.Raw("SELECT NOW()").Scan(&now).Error
 	if err != nil {
-		return 0, err
+		log.Println(err)
+		return time.Time{}, errors.New("服务器错误")
 	}
+	return now, nil
+}
 
-	newID := utils.NewUniqueID()
-	newMsg := model.Message{
-		SenderID:       senderID,
-		ConversationID: conversationID,
-		Status:         model.TEXT,
-		MyModel: model.MyModel{
-			ID: newID,
-		},
+// stableMessageID 返回可以用作增量游标的消息ID上界
+// 雪花ID按生成的先后递增，消息却按事务提交的先后出现，ID较小的消息可能较晚才能查到
+// 不大于该值的消息都已经提交或回滚，游标不超过它就不会漏掉消息
+func stableMessageID() uint64 {
+	return utils.MaxIDAt(time.Now().Add(-(messageTxTimeout + messageCommitMargin)))
+}
+
+// SendText 发送文本消息，replyToID 为回复的消息，不是回复时为 0
+func SendText(senderID, conversationID, replyToID uint64, content string) (uint64, error) {
+	err := sendMessageAuth(senderID, conversationID)
+	if err != nil {
+		return 0, err
 	}
-	newText := model.Text{
-		Text:      content,
-		MessageID: newID,
+	err = checkReplyTo(conversationID, replyToID)
+	if err != nil {
+		return 0, err
 	}
-	db := infra.GetDB()
-	return newID, db.Transaction(func(tx *gorm.DB) error {
+
+	var newID uint64
+	err = messageTransaction(func(tx *gorm.DB) error {
+		newID = utils.NewUniqueID()
+		newMsg := model.Message{
+			SenderID:         senderID,
+			ConversationID:   conversationID,
+			Status:           model.TEXT,
+			ReplyToMessageID: replyToID,
+			MyModel: model.MyModel{
+				ID: newID,
+			},
+		}
+		newText := model.Text{
+			Text:      content,
+			MessageID: newID,
+		}
+
 		res := tx.Create(&newMsg)
 		if res.Error != nil {
 			log.Println(res.Error)
@@ -140,58 +216,80 @@ func SendText(senderID, conversationID uint64, content string) (uint64, error) {
 
 		return nil
 	})
+	if err != nil {
+		return 0, err
+	}
+
+	pushNewMessage(newID)
+	return newID, nil
 }
 
-func SendFile(senderID, conversationID uint64, file *multipart.FileHeader) (*model.SendFileResp, error) {
+// SendFile 发送文件消息，replyToID 为回复的消息，不是回复时为 0
+func SendFile(senderID, conversationID, replyToID uint64, file *multipart.FileHeader) (*model.SendFileResp, error) {
 	err := sendMessageAuth(senderID, conversationID)
 	if err != nil {
 		return nil, err
 	}
+	err = checkReplyTo(conversationID, replyToID)
+	if err != nil {
+		return nil, err
+	}
 
-	newID := utils.NewUniqueID()
-
-	// 获取 uploads 目录
-	uploadDir := infra.GetFilePath()
-
-	// 生成文件路径，使用newID作为文件名，保持原扩展名
-	ext := filepath.Ext(file.Filename)
-	fileName := strings.TrimSuffix(file.Filename, ext) // 原文件名
-	filePath := filepath.Join(uploadDir, fmt.Sprintf("%d%s", newID, ext))
+	err = checkUploadSize(senderID, conversationID, file.Size, 0)
+	if err != nil {
+		return nil, err
+	}
 
-	// 保存文件
-	if err := saveFile(file, filePath); err != nil {
+	// 保存文件，存储中的文件名是内容的 sha256
+	blob, err := saveFile(file)
+	if err != nil {
+		if isPolicyError(err) {
+			return nil, err
+		}
 		log.Println(err)
 		return nil, errors.New("服务器错误")
 	}
 
-	// 获取文件信息
-	fileSize := file.Size
-	fileType := getFileType(filePath)
+	return createFileMessage(senderID, conversationID, replyToID, file.Filename, blob)
+}
 
-	newMsg := model.Message{
-		SenderID:       senderID,
-		ConversationID: conversationID,
-		Status:         model.FILE,
-		MyModel: model.MyModel{
-			ID: newID,
-		},
-	}
-	newFile := model.File{
-		FileName:  fileName,
-		FileType:  fileType,
-		FileURL:   filePath,
-		FileSize:  fileSize,
-		MessageID: newID,
-	}
+// createFileMessage 为已经写入存储的文件创建文件消息，并增加一次 Blob 的引用
+// fileName 为带扩展名的原文件名
+func createFileMessage(senderID, conversationID, replyToID uint64, fileName string, blob *model.Blob) (*model.SendFileResp, error) {
+	ext := filepath.Ext(fileName)
+	fileName = strings.TrimSuffix(fileName, ext) // 原文件名
+	fileSize := blob.Size
+	fileType := blob.ContentType
 
-	db := infra.GetDB()
 	resp := &model.SendFileResp{
-		MessageID: newID,
-		FileName:  fileName,
-		FileSize:  fileSize,
-		FileType:  fileType,
+		FileName:   fileName,
+		FileSize:   fileSize,
+		FileType:   fileType,
+		ScanStatus: initialScanStatus(),
 	}
-	return resp, db.Transaction(func(tx *gorm.DB) error {
+	var newID uint64
+	err := messageTransaction(func(tx *gorm.DB) error {
+		newID = utils.NewUniqueID()
+		newMsg := model.Message{
+			SenderID:         senderID,
+			ConversationID:   conversationID,
+			Status:           model.FILE,
+			ReplyToMessageID: replyToID,
+			MyModel: model.MyModel{
+				ID: newID,
+			},
+		}
+		newFile := model.File{
+			FileName:   fileName,
+			FileExt:    ext,
+			FileType:   fileType,
+			FileURL:    blob.StorageKey,
+			FileSize:   fileSize,
+			MessageID:  newID,
+			BlobID:     blob.ID,
+			ScanStatus: resp.ScanStatus,
+		}
+
 		res := tx.Create(&newMsg)
 		if res.Error != nil {
 			log.Println(res.Error)
@@ -204,7 +302,12 @@ func SendFile(senderID, conversationID uint64, file *multipart.FileHeader) (*mod
 			return errors.New("服务器错误")
 		}
 
-		err := updateLastMessageID(tx, conversationID, newID)
+		err := acquireBlob(tx, blob.ID)
+		if err != nil {
+			return err
+		}
+
+		err = updateLastMessageID(tx, conversationID, newID)
 		if err != nil {
 			return errors.New("服务器错误")
 		}
@@ -216,15 +319,33 @@ func SendFile(senderID, conversationID uint64, file *multipart.FileHeader) (*mod
 
 		return nil
 	})
+	if err != nil {
+		// 消息没有保存成功时 Blob 没有被引用，之后会被垃圾回收
+		return nil, err
+	}
+	resp.MessageID = newID
+
+	pushNewMessage(newID)
+	go processBlobMedia(blob)
+	// 需要扫描的文件在通过扫描后再提取内容
+	if resp.ScanStatus == model.SCAN_CLEAN {
+		go indexFileContent(newID, blob, fileName+ext, fileType)
+	} else {
+		go scanBlob(blob)
+	}
+	return resp, nil
 }
 
-func DownloadFile(userID, messageID uint64) (string, error) {
+// DownloadFile 校验用户是否可以下载该文件，返回文件记录
+// 没有通过安全扫描的文件不能下载
+// 返回的 FileURL 已转换为存储中的 key
+func DownloadFile(userID, messageID uint64) (*model.File, error) {
 	db := infra.GetDB()
 
 	// 一次查询完成：消息存在 + 用户在对话中 + 文件存在
 	var file model.File
 	err := db.Model(&model.File{}).
-		Select("files.file_url").
+		Select("files.message_id, files.file_name, files.file_ext, files.file_type, files.file_url, files.file_size, files.scan_status, files.created_at").
 		Joins("JOIN messages m ON m.id = files.message_id").
 		Joins("JOIN conversation_users cu ON cu.conversation_id = m.conversation_id").
 		Where("files.message_id = ? AND cu.user_id = ? AND m.status = ?",
@@ -233,20 +354,25 @@ func DownloadFile(userID, messageID uint64) (string, error) {
 
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return "", errors.New("文件不存在或无访问权限")
+			return nil, errors.New("文件不存在或无访问权限")
 		}
 		log.Println("DB error:", err)
-		return "", errors.New("服务器错误")
+		return nil, errors.New("服务器错误")
+	}
+	err = checkScanStatus(file.ScanStatus)
+	if err != nil {
+		return nil, err
 	}
 
-	return file.FileURL, nil
+	file.FileURL = fileKey(file.FileURL)
+	return &file, nil
 }
 
 func RecallMessage(userID, msgID uint64) (uint64, error) {
 	db := infra.GetDB()
 	var temp model.Message
 	err := db.Model(&model.Message{}).
-		Select("sender_id, conversation_id").
+		Select("sender_id, conversation_id, status").
 		Where("id = ?", msgID).
 		First(&temp).
 		Error
@@ -260,19 +386,34 @@ func RecallMessage(userID, msgID uint64) (uint64, error) {
 	if senderID != userID {
 		return 0, errors.New("不能撤回不是自己发的消息")
 	}
+	if temp.Status == model.RECALLED {
+		return 0, errors.New("消息已撤回")
+	}
 
-	newID := utils.NewUniqueID()
-	return newID, db.Transaction(func(tx *gorm.DB) error {
+	var newID uint64
+	err = messageTransaction(func(tx *gorm.DB) error {
+		newID = utils.NewUniqueID()
+		// 带上原状态作为条件，并发撤回时只有一个能成功
 		res := tx.Model(&model.Message{}).
-			Where("id = ?", msgID).
+			Where("id = ? AND status = ?", msgID, temp.Status).
 			Update("status", model.RECALLED)
 		if res.Error != nil {
 			log.Println(res.Error)
 			return errors.New("服务器错误")
 		}
 		if res.RowsAffected == 0 {
-			log.Println("撤回消息操作影响了0行表")
-			return errors.New("服务器错误")
+			return errors.New("消息已撤回")
+		}
+
+		// 撤回的文件不能再下载，释放对文件内容的引用
+		switch temp.Status {
+		case model.FILE:
+			err = releaseMessageBlob(tx, msgID)
+		case model.CHAT_RECORD:
+			err = releaseChatRecordBlobs(tx, msgID)
+		}
+		if err != nil {
+			return err
 		}
 
 		var senderName string
@@ -295,58 +436,206 @@ func RecallMessage(userID, msgID uint64) (uint64, error) {
 		}
 		return nil
 	})
+	if err != nil {
+		return 0, err
+	}
+
+	pushToConversation(conversationID, ws.EventMessageRecalled, model.MessageRecalledEventResp{
+		ConversationID: conversationID,
+		MessageID:      msgID,
+	})
+	pushNewMessage(newID)
+	return newID, nil
 }
 
-func DeleteMessage(userID, messageID uint64) error {
+// EditMessage 编辑自己发送的文本消息，只能在发送后的一段时间内编辑
+// 编辑前的内容保存到 message_revisions
+func EditMessage(userID, messageID uint64, content string) error {
 	db := infra.GetDB()
 	var msg model.Message
+	err := db.Select("sender_id, conversation_id, status, created_at").
+		Where("id = ?", messageID).
+		Take(&msg).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("消息不存在")
+		}
+		log.Println(err)
+		return errors.New("服务器错误")
+	}
+
+	if msg.SenderID != userID {
+		return errors.New("不能编辑不是自己发的消息")
+	}
+	switch msg.Status {
+	case model.TEXT:
+	case model.RECALLED:
+		return errors.New("消息已撤回")
+	default:
+		return errors.New("只能编辑文本消息")
+	}
+	window := infra.GetEditWindow()
+	if window > 0 && time.Since(msg.CreatedAt) > window {
+		return errors.New("消息发送时间过久，不能再编辑")
+	}
+	// 已经不在会话中的用户不能再编辑
+	err = sendMessageAuth(userID, msg.ConversationID)
+	if err != nil {
+		return err
+	}
+
+	// 离线同步按 edited_at 查找编辑过的消息，同样需要限时提交
+	err = messageTransaction(func(tx *gorm.DB) error {
+		// 锁住原内容，并发编辑时依次保存历史
+		var text model.Text
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id, text").
+			Where("message_id = ?", messageID).
+			Take(&text).Error
+		if err != nil {
+			log.Println(err)
+			return errors.New("服务器错误")
+		}
+		if text.Text == content {
+			return errors.New("消息内容没有变化")
+		}
+
+		res := tx.Create(&model.MessageRevision{
+			MessageID: messageID,
+			Text:      text.Text,
+		})
+		if res.Error != nil {
+			log.Println(res.Error)
+			return errors.New("服务器错误")
+		}
+
+		res = tx.Model(&model.Text{}).
+			Where("id = ?", text.ID).
+			Update("text", content)
+		if res.Error != nil {
+			log.Println(res.Error)
+			return errors.New("服务器错误")
+		}
+
+		// 不更新 updated_at，聊天记录中显示的仍是发送时间
+		// 带上状态作为条件，与撤回同时发生时以撤回为准
+		res = tx.Model(&model.Message{}).
+			Where("id = ? AND status = ?", messageID, model.TEXT).
+			UpdateColumn("edited_at", time.Now())
+		if res.Error != nil {
+			log.Println(res.Error)
+			return errors.New("服务器错误")
+		}
+		if res.RowsAffected == 0 {
+			return errors.New("消息已撤回")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	pushEditedMessage(messageID)
+	return nil
+}
 
+// MessageRevisions 查询消息编辑前的各个版本，按编辑时间从早到晚排列
+// 只有会话成员可以查看，已撤回的消息不能查看
+func MessageRevisions(userID, messageID uint64) ([]model.MessageRevisionResp, error) {
+	db := infra.GetDB()
+	var cnt int64
 	err := db.Model(&model.Message{}).
-		Select("conversation_id").
-		Where("id = ?", messageID).
-		First(&msg).
-		Error
+		Joins("JOIN conversation_users cu ON cu.conversation_id = messages.conversation_id").
+		Where("messages.id = ? AND cu.user_id = ? AND messages.status = ?", messageID, userID, model.TEXT).
+		Count(&cnt).Error
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+	if cnt == 0 {
+		return nil, errors.New("消息不存在或无访问权限")
+	}
 
+	revisions := make([]model.MessageRevisionResp, 0)
+	err = db.Model(&model.MessageRevision{}).
+		Select("text, created_at AS edited_at").
+		Where("message_id = ?", messageID).
+		Order("id ASC").
+		Scan(&revisions).Error
 	if err != nil {
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+	return revisions, nil
+}
+
+// DeleteMessage 删除消息，只对自己隐藏，其他成员不受影响
+func DeleteMessage(userID, messageID uint64) error {
+	db := infra.GetDB()
+	var msg model.Message
+	err := db.Select("conversation_id").
+		Where("id = ?", messageID).
+		Take(&msg).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("消息不存在")
+		}
 		log.Println(err)
 		return errors.New("服务器错误")
 	}
 	conversationID := msg.ConversationID
+	err = sendMessageAuth(userID, conversationID)
+	if err != nil {
+		return err
+	}
 
-	return db.Transaction(func(tx *gorm.DB) error {
-		res := tx.Where("user_id = ? AND message_id = ?", userID, messageID).
-			Delete(&model.MessageUser{})
+	// 离线同步按 deleted_at 查找删除的消息，需要限时提交
+	return messageTransaction(func(tx *gorm.DB) error {
+		now, err := transactionTime(tx)
+		if err != nil {
+			return err
+		}
 
+		// 用户还没有该消息的 message_users 记录时创建一条已删除的记录
+		// 已经删除过时冲突不更新，影响行数为 0
+		res := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "message_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"deleted_at", "updated_at"}),
+			Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "message_users.deleted_at IS NULL"}}},
+		}).Create(&model.MessageUser{
+			MyModel:   model.MyModel{UpdatedAt: now, DeletedAt: gorm.DeletedAt{Time: now, Valid: true}},
+			UserID:    userID,
+			MessageID: messageID,
+		})
 		if res.Error != nil {
 			log.Println(res.Error)
 			return errors.New("服务器错误")
 		}
 		if res.RowsAffected == 0 {
-			log.Println("删除消息操作影响了0行表")
-			return errors.New("服务器错误")
+			return nil
 		}
 
+		// 重新计算该用户在这个会话中能看到的最后一条消息，没有时为 0
 		var lastID uint64
-		sql := `SELECT m.id FROM messages m 
+		sql := `SELECT m.id FROM messages m
 			LEFT JOIN message_users mu ON mu.message_id = m.id AND mu.user_id = ?
-			WHERE m.status != ? AND mu.deleted_at IS NULL
-			ORDER BY m.created_at DESC 
+			WHERE m.conversation_id = ? AND m.status != ? AND mu.deleted_at IS NULL
+			ORDER BY m.id DESC
 			LIMIT 1`
-		res = tx.Raw(sql, userID, model.RECALLED).Scan(&lastID)
+		res = tx.Raw(sql, userID, conversationID, model.RECALLED).Scan(&lastID)
 		if res.Error != nil {
 			log.Println(res.Error)
 			return errors.New("服务器错误")
 		}
-		if res.RowsAffected == 0 {
-			log.Println("删除消息更新最后消息id 时没有查到id")
-			return errors.New("服务器错误")
-		}
 
-		err = updateLastMessageID(tx, conversationID, lastID)
-		if err != nil {
-			return err
+		// 只修改自己的最后一条消息，不影响其他成员
+		res = tx.Unscoped().Model(&model.ConversationUser{}).
+			Where("user_id = ? AND conversation_id = ?", userID, conversationID).
+			Update("last_message_id", lastID)
+		if res.Error != nil {
+			log.Println(res.Error)
+			return errors.New("服务器错误")
 		}
-
 		return nil
 	})
 }
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/internal/ws"
+	"github.com/lojes7/inquire/pkg/infra"
+)
+
+// UpdatePresence 用户上线或下线时调用
+// 记录最后在线时间，并把当前在线状态推送给该用户的所有好友
+func UpdatePresence(userID uint64) {
+	db := infra.GetDB()
+	now := time.Now()
+
+	err := db.Model(&model.User{}).
+		Where("id = ?", userID).
+		Update("last_seen_at", now).Error
+	if err != nil {
+		log.Println(err)
+	}
+
+	var friendIDs []uint64
+	err = db.Model(&model.Friendship{}).
+		Where("user_id = ?", userID).
+		Pluck("friend_id", &friendIDs).Error
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	pushToUsers(friendIDs, ws.EventPresence, model.PresenceResp{
+		UserID:     userID,
+		Online:     ws.GetHub().IsOnline(userID),
+		LastSeenAt: &now,
+	})
+}
+
+// FriendsPresence 查询所有好友的在线状态
+func FriendsPresence(userID uint64) ([]model.PresenceResp, error) {
+	resp := make([]model.PresenceResp, 0)
+	err := infra.GetDB().Model(&model.User{}).
+		Select("users.id, users.last_seen_at").
+		Joins("JOIN friendships f ON f.friend_id = users.id AND f.deleted_at IS NULL").
+		Where("f.user_id = ?", userID).
+		Find(&resp).Error
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+
+	hub := ws.GetHub()
+	for i := range resp {
+		resp[i].Online = hub.IsOnline(resp[i].UserID)
+	}
+	return resp, nil
+}
+
+// SendTyping 把正在输入状态转发给会话中的其他成员
+// 只做转发，不写数据库
+func SendTyping(userID, conversationID uint64, typing bool) error {
+	var userIDs []uint64
+	err := infra.GetDB().Unscoped().
+		Model(&model.ConversationUser{}).
+		Where("conversation_id = ?", conversationID).
+		Pluck("user_id", &userIDs).Error
+	if err != nil {
+		log.Println(err)
+		return errors.New("服务器错误")
+	}
+	if !slices.Contains(userIDs, userID) {
+		return errors.New("不在该会话中")
+	}
+
+	others := slices.DeleteFunc(userIDs, func(id uint64) bool {
+		return id == userID
+	})
+	pushToUsers(others, ws.EventTyping, model.TypingEventResp{
+		ConversationID: conversationID,
+		UserID:         userID,
+		Typing:         typing,
+	})
+	return nil
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/internal/ws"
+	"github.com/lojes7/inquire/pkg/infra"
+)
+
+// pushToUsers 通过 websocket 把事件推送给指定用户
+// 推送失败只记录日志，不影响业务
+func pushToUsers(userIDs []uint64, eventType string, data any) {
+	msgBytes, err := json.Marshal(ws.Event{Type: eventType, Data: data})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	hub := ws.GetHub()
+	for _, userID := range userIDs {
+		hub.SendToUser(userID, msgBytes)
+	}
+}
+
+// pushToConversation 把事件推送给会话中的所有成员
+// 隐藏了会话的成员同样会收到推送
+func pushToConversation(conversationID uint64, eventType string, data any) {
+	var userIDs []uint64
+	err := infra.GetDB().Unscoped().
+		Model(&model.ConversationUser{}).
+		Where("conversation_id = ?", conversationID).
+		Pluck("user_id", &userIDs).Error
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	pushToUsers(userIDs, eventType, data)
+}
+
+// pushNewMessage 把一条新消息推送给会话中的所有成员
+// extraUserIDs 用于通知已经不在会话中的用户，比如被移出群聊的成员
+func pushNewMessage(messageID uint64, extraUserIDs ...uint64) {
+	msg, ok := messageEvent(messageID)
+	if !ok {
+		return
+	}
+
+	pushToConversation(msg.ConversationID, ws.EventNewMessage, msg)
+	if len(extraUserIDs) > 0 {
+		pushToUsers(extraUserIDs, ws.EventNewMessage, msg)
+	}
+}
+
+// pushEditedMessage 把编辑后的消息推送给会话中的所有成员
+func pushEditedMessage(messageID uint64) {
+	msg, ok := messageEvent(messageID)
+	if !ok {
+		return
+	}
+
+	pushToConversation(msg.ConversationID, ws.EventMessageEdited, msg)
+}
+
+// messageEvent 查询推送用的消息体，查询失败时返回 false
+func messageEvent(messageID uint64) (*model.MessageEventResp, bool) {
+	var msg model.MessageEventResp
+	args := append(chatMessageArgs(), messageID)
+	res := infra.GetDB().Raw(chatMessageSelect+` WHERE m.id = ?`, args...).Scan(&msg)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return nil, false
+	}
+	if res.RowsAffected == 0 {
+		log.Println("推送消息时没有查到消息")
+		return nil, false
+	}
+	return &msg, true
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/internal/ws"
+	"github.com/lojes7/inquire/pkg/infra"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxEmojiRunes 一个表情最多包含的字符数，组合表情（如肤色、家庭）由多个字符组成
+const maxEmojiRunes = 10
+
+// validEmoji 粗略判断是否为一个表情，不允许文字、空白和控制字符
+func validEmoji(emoji string) bool {
+	count := utf8.RuneCountInString(emoji)
+	if count == 0 || count > maxEmojiRunes || !utf8.ValidString(emoji) {
+		return false
+	}
+	hasSymbol := false
+	for _, r := range emoji {
+		if unicode.IsLetter(r) || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+		if r >= 0x2000 {
+			hasSymbol = true
+		}
+	}
+	return hasSymbol
+}
+
+// ToggleReaction 对消息进行表情回应
+// 已经用同一个表情回应过时取消，用其它表情回应过时替换为新的表情
+// 回应不产生新消息，不更新最后一条消息和未读数
+func ToggleReaction(userID, messageID uint64, emoji string) (*model.ReactResp, error) {
+	if !validEmoji(emoji) {
+		return nil, errors.New("表情格式错误")
+	}
+
+	db := infra.GetDB()
+	var msg model.Message
+	err := db.Select("conversation_id, status").
+		Where("id = ?", messageID).
+		Take(&msg).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("消息不存在")
+		}
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+	if msg.Status == model.RECALLED {
+		return nil, errors.New("消息已撤回")
+	}
+	err = sendMessageAuth(userID, msg.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	current := emoji
+	// 离线同步按 updated_at 查找回应的变化，需要限时提交
+	err = messageTransaction(func(tx *gorm.DB) error {
+		now, err := transactionTime(tx)
+		if err != nil {
+			return err
+		}
+
+		// 取消时软删除并更新 updated_at，其它设备离线同步时才能知道
+		res := tx.Model(&model.MessageReaction{}).
+			Where("message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji).
+			Updates(map[string]any{"deleted_at": now, "updated_at": now})
+		if res.Error != nil {
+			log.Println(res.Error)
+			return errors.New("服务器错误")
+		}
+		if res.RowsAffected > 0 {
+			current = ""
+			return nil
+		}
+
+		// 取消过的回应留在唯一索引上，再次回应时恢复这条记录
+		res = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "message_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"emoji", "updated_at", "deleted_at"}),
+		}).Create(&model.MessageReaction{
+			MyModel:   model.MyModel{CreatedAt: now, UpdatedAt: now},
+			MessageID: messageID,
+			UserID:    userID,
+			Emoji:     emoji,
+		})
+		if res.Error != nil {
+			log.Println(res.Error)
+			return errors.New("服务器错误")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reactions, err := messageReactions(userID, []uint64{messageID})
+	if err != nil {
+		return nil, err
+	}
+	resp := &model.ReactResp{
+		Emoji:     current,
+		Reactions: reactions[messageID],
+	}
+	if resp.Reactions == nil {
+		resp.Reactions = []model.ReactionResp{}
+	}
+
+	counts := make([]model.ReactionCountResp, 0, len(resp.Reactions))
+	for _, reaction := range resp.Reactions {
+		counts = append(counts, model.ReactionCountResp{Emoji: reaction.Emoji, Count: reaction.Count})
+	}
+	pushToConversation(msg.ConversationID, ws.EventMessageReaction, model.MessageReactionEventResp{
+		ConversationID: msg.ConversationID,
+		MessageID:      messageID,
+		UserID:         userID,
+		Emoji:          current,
+		Reactions:      counts,
+	})
+	return resp, nil
+}
+
+// messageReactions 按消息汇总表情回应，同一条消息上的表情按第一次出现的先后排列
+// reacted_by_me 相对于 viewerID
+func messageReactions(viewerID uint64, messageIDs []uint64) (map[uint64][]model.ReactionResp, error) {
+	result := make(map[uint64][]model.ReactionResp)
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+
+	var rows []struct {
+		MessageID uint64
+		model.ReactionResp
+	}
+	err := infra.GetDB().Model(&model.MessageReaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, bool_or(user_id = ?) AS reacted_by_me", viewerID).
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, emoji").
+		Order("message_id, MIN(id)").
+		Scan(&rows).Error
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+	for _, row := range rows {
+		result[row.MessageID] = append(result[row.MessageID], row.ReactionResp)
+	}
+	return result, nil
+}
+
+// attachReactions 把表情回应填入查询到的消息中
+func attachReactions(viewerID uint64, messages []*model.ChatHistoryResp) error {
+	messageIDs := make([]uint64, 0, len(messages))
+	for _, msg := range messages {
+		messageIDs = append(messageIDs, msg.MessageID)
+	}
+	reactions, err := messageReactions(viewerID, messageIDs)
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		msg.Reactions = reactions[msg.MessageID]
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"errors"
+	"log"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/internal/ws"
+	"github.com/lojes7/inquire/pkg/infra"
+)
+
+// MarkConversationRead 把会话标记为已读到某条消息
+// 已读位置只会前进不会后退，未读数按已读位置之后他人发送、自己没有删除的消息重新计算
+// 成功后把已读回执推送给会话中的所有成员
+func MarkConversationRead(userID, conversationID, messageID uint64) error {
+	db := infra.GetDB()
+
+	sql := `UPDATE conversation_users cu
+			SET last_read_message_id = GREATEST(cu.last_read_message_id, ?),
+			unread_count = (
+				SELECT COUNT(*) FROM messages m
+				WHERE m.conversation_id = cu.conversation_id
+				AND m.sender_id != cu.user_id
+				AND m.status IN (?, ?, ?)
+				AND m.id > GREATEST(cu.last_read_message_id, ?)
+				AND NOT EXISTS (SELECT 1 FROM message_users mu
+					WHERE mu.message_id = m.id AND mu.user_id = cu.user_id AND mu.deleted_at IS NOT NULL)
+			),
+			read_at = NOW()
+			WHERE cu.user_id = ? AND cu.conversation_id = ?
+			AND EXISTS (SELECT 1 FROM messages WHERE id = ? AND conversation_id = ?)
+			RETURNING cu.last_read_message_id`
+
+	var lastReadID uint64
+	res := db.Raw(sql, messageID,
+		model.TEXT,
+		model.FILE,
+		model.CHAT_RECORD,
+		messageID,
+		userID,
+		conversationID,
+		messageID,
+		conversationID).
+		Scan(&lastReadID)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return errors.New("服务器错误")
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("消息不存在或不在该会话中")
+	}
+
+	pushToConversation(conversationID, ws.EventMessageRead, model.MessageReadEventResp{
+		ConversationID:    conversationID,
+		UserID:            userID,
+		LastReadMessageID: lastReadID,
+	})
+	return nil
+}
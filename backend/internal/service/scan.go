@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/internal/ws"
+	"github.com/lojes7/inquire/pkg/infra"
+	"github.com/lojes7/inquire/pkg/scanner"
+)
+
+const (
+	// scanTimeout 扫描一个文件的超时时间
+	scanTimeout = 10 * time.Minute
+	// scanRetryInterval 重新扫描仍在等待的文件的间隔
+	scanRetryInterval = 5 * time.Minute
+	// scanRetryDelay 文件等待超过该时间仍未扫描完时才重试，避免与刚发送时的扫描重复
+	scanRetryDelay = 10 * time.Minute
+	// maxScanAttempts 同一内容最多扫描的次数，都失败时文件标记为扫描失败
+	maxScanAttempts = 5
+)
+
+var (
+	// ErrFileScanPending 文件还没有完成安全扫描
+	ErrFileScanPending = errors.New("文件正在进行安全检查，请稍后再试")
+	// ErrFileInfected 文件没有通过安全扫描
+	ErrFileInfected = errors.New("文件未通过安全检查，禁止下载")
+	// ErrFileScanFailed 文件多次扫描都没有完成
+	ErrFileScanFailed = errors.New("文件无法完成安全检查，禁止下载")
+)
+
+// initialScanStatus 新文件的扫描状态，没有配置扫描器时直接视为通过
+func initialScanStatus() uint8 {
+	if infra.GetScanner() == nil {
+		return model.SCAN_CLEAN
+	}
+	return model.SCAN_PENDING
+}
+
+// checkScanStatus 只有通过扫描的文件可以下载
+func checkScanStatus(status uint8) error {
+	switch status {
+	case model.SCAN_CLEAN:
+		return nil
+	case model.SCAN_PENDING:
+		return ErrFileScanPending
+	case model.SCAN_FAILED:
+		return ErrFileScanFailed
+	}
+	return ErrFileInfected
+}
+
+// scanBlob 扫描文件内容，把结果写入引用该内容且仍在等待扫描的文件，并通知所在的会话
+// 相同内容已经有扫描结果时直接复用；扫描失败时文件保持等待状态，之后由 ScanPendingFiles 重试
+// 没有配置扫描器时文件发送时就已通过，不需要扫描
+func scanBlob(blob *model.Blob) {
+	fileScanner := infra.GetScanner()
+	if fileScanner == nil {
+		return
+	}
+
+	db := infra.GetDB()
+	var scanned model.File
+	res := db.Select("scan_status, scan_signature").
+		Where("blob_id = ? AND scan_status IN ?", blob.ID, []uint8{model.SCAN_CLEAN, model.SCAN_INFECTED}).
+		Limit(1).
+		Find(&scanned)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return
+	}
+
+	if res.RowsAffected == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), scanTimeout)
+		defer cancel()
+
+		result, err := scanContent(ctx, fileScanner, blob)
+		if err != nil {
+			log.Println("扫描文件失败:", blob.Hash, err)
+			recordScanFailure(blob)
+			return
+		}
+		scanned.ScanStatus = model.SCAN_CLEAN
+		if result.Infected {
+			log.Println("发现感染的文件:", blob.Hash, result.Signature)
+			scanned.ScanStatus = model.SCAN_INFECTED
+			scanned.ScanSignature = result.Signature
+		}
+	}
+
+	markScanned(blob, scanned.ScanStatus, scanned.ScanSignature)
+}
+
+// scanContent 从存储中读取内容并扫描
+func scanContent(ctx context.Context, fileScanner scanner.Scanner, blob *model.Blob) (*scanner.Result, error) {
+	content, _, err := infra.GetStorage().Get(ctx, blob.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	return fileScanner.Scan(ctx, content)
+}
+
+// recordScanFailure 记录一次扫描失败，达到 maxScanAttempts 次后把等待中的文件标记为扫描失败
+func recordScanFailure(blob *model.Blob) {
+	var attempts int
+	err := infra.GetDB().
+		Raw(`UPDATE blobs SET scan_attempts = scan_attempts + 1 WHERE id = ? RETURNING scan_attempts`, blob.ID).
+		Scan(&attempts).Error
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if attempts >= maxScanAttempts {
+		log.Println("多次扫描失败，不再重试:", blob.Hash)
+		markScanned(blob, model.SCAN_FAILED, "")
+	}
+}
+
+// markScanned 把扫描结果写入引用该内容且仍在等待扫描的文件，并通知所在的会话
+// 通过扫描的文件接着提取内容用于搜索，未通过的文件不读取内容
+func markScanned(blob *model.Blob, status uint8, signature string) {
+	var updated []struct {
+		model.FileScannedEventResp
+		FileName     string
+		FileExt      string
+		FileType     string
+		HasThumbnail bool
+	}
+	err := infra.GetDB().Raw(`UPDATE files f SET scan_status = ?, scan_signature = ?, updated_at = ?
+			FROM messages m
+			WHERE m.id = f.message_id AND f.blob_id = ? AND f.scan_status = ?
+			RETURNING m.conversation_id, f.message_id, f.scan_status, f.file_name, f.file_ext, f.file_type,
+				(SELECT has_thumbnail FROM blobs WHERE id = f.blob_id) AS has_thumbnail`,
+		status, signature, time.Now(), blob.ID, model.SCAN_PENDING).
+		Scan(&updated).Error
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, event := range updated {
+		if event.HasThumbnail && event.ScanStatus == model.SCAN_CLEAN {
+			event.ThumbnailURL = thumbnailURL(event.MessageID)
+		}
+		pushToConversation(event.ConversationID, ws.EventFileScanned, event.FileScannedEventResp)
+	}
+	if status != model.SCAN_CLEAN {
+		return
+	}
+	// 依次提取，相同内容的文件第一次提取后其余的直接复用结果
+	for _, file := range updated {
+		indexFileContent(file.MessageID, blob, file.FileName+file.FileExt, file.FileType)
+	}
+}
+
+// ScanPendingFiles 定期重新扫描等待中的文件，需要在单独的 goroutine 中运行
+// 扫描服务暂时不可用或者扫描过程中服务重启时，文件会一直处于等待状态
+func ScanPendingFiles() {
+	if infra.GetScanner() == nil {
+		return
+	}
+
+	ticker := time.NewTicker(scanRetryInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		scanPendingFiles()
+	}
+}
+
+func scanPendingFiles() {
+	var blobs []model.Blob
+	err := infra.GetDB().
+		Where("scan_attempts < ? AND id IN (SELECT blob_id FROM files WHERE scan_status = ? AND updated_at < ?)",
+			maxScanAttempts, model.SCAN_PENDING, time.Now().Add(-scanRetryDelay)).
+		Limit(100).
+		Find(&blobs).Error
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for i := range blobs {
+		scanBlob(&blobs[i])
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"html"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/pkg/infra"
+)
+
+// defaultSearchLimit 未指定时每页返回的搜索结果数
+const defaultSearchLimit = 20
+
+// SearchMessages 在用户所在的会话中搜索文本消息和文件名
+// 英文等有空格分词的内容走 tsvector 全文索引，中文等没有空格的内容走 pg_trgm 子串索引
+// 已撤回的消息、系统消息以及用户自己删除的消息不会出现在结果中
+// 结果按消息ID从新到旧排列，用 before 游标翻页
+func SearchMessages(userID uint64, req model.SearchMessageReq) (*model.SearchMessagePageResp, error) {
+	db := infra.GetDB()
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+	pattern := "%" + escapeLike(req.Q) + "%"
+
+	sql := chatMessageSelect + `
+			JOIN conversation_users cu ON cu.conversation_id = m.conversation_id AND cu.user_id = ?
+			LEFT JOIN message_users mu ON mu.message_id = m.id AND mu.user_id = ?
+			WHERE mu.deleted_at IS NULL AND (
+				(m.status = ? AND (t.text_tsv @@ websearch_to_tsquery('simple', ?) OR t.text ILIKE ?))
+				OR (m.status = ? AND (f.file_name_tsv @@ websearch_to_tsquery('simple', ?) OR f.file_name ILIKE ?))
+			)`
+	args := append(chatMessageArgs(), userID, userID,
+		model.TEXT, req.Q, pattern,
+		model.FILE, req.Q, pattern)
+
+	if req.ConversationID > 0 {
+		sql += ` AND m.conversation_id = ?`
+		args = append(args, req.ConversationID)
+	}
+	if req.Before > 0 {
+		sql += ` AND m.id < ?`
+		args = append(args, req.Before)
+	}
+	sql += ` ORDER BY m.id DESC LIMIT ?`
+	args = append(args, limit+1)
+
+	messages := make([]model.SearchMessageResp, 0, limit+1)
+	res := db.Raw(sql, args...).Scan(&messages)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return nil, errors.New("服务器错误")
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	terms := searchTerms(req.Q)
+	views := make([]*model.ChatHistoryResp, 0, len(messages))
+	for i := range messages {
+		messages[i].Highlight = highlight(matchedText(&messages[i].ChatHistoryResp), terms)
+		views = append(views, &messages[i].ChatHistoryResp)
+	}
+	if err := attachReactions(userID, views); err != nil {
+		return nil, err
+	}
+
+	nextCursor := req.Before
+	if len(messages) > 0 {
+		nextCursor = messages[len(messages)-1].MessageID
+	}
+
+	return &model.SearchMessagePageResp{
+		Messages:   messages,
+		NextCursor: nextCursor,
+		HasMore:    hasMore,
+	}, nil
+}
+
+// escapeLike 转义 LIKE 中的通配符
+func escapeLike(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(s)
+}
+
+// searchTerms 从搜索词中取出需要高亮的关键词
+// 与 websearch_to_tsquery 的语法保持一致：去掉引号、or 以及以 - 开头的排除词
+func searchTerms(q string) []string {
+	terms := make([]string, 0)
+	for _, field := range strings.Fields(q) {
+		field = strings.Trim(field, `"`)
+		if field == "" || strings.HasPrefix(field, "-") || strings.EqualFold(field, "or") {
+			continue
+		}
+		terms = append(terms, field)
+	}
+	return terms
+}
+
+// matchedText 取出消息中参与搜索的文本：文本消息为正文，文件消息为文件名
+func matchedText(msg *model.ChatHistoryResp) string {
+	if msg.Status == model.FILE {
+		var content struct {
+			FileName string `json:"file_name"`
+		}
+		if err := json.Unmarshal(msg.Content, &content); err != nil {
+			log.Println(err)
+			return ""
+		}
+		return content.FileName
+	}
+
+	var text string
+	if err := json.Unmarshal(msg.Content, &text); err != nil {
+		log.Println(err)
+		return ""
+	}
+	return text
+}
+
+// highlight 把文本中命中关键词的部分用 <em></em> 包裹，其余部分做 html 转义
+// 按字符忽略大小写匹配
+func highlight(text string, terms []string) string {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	marked := make([]bool, len(runes))
+	for _, term := range terms {
+		termRunes := []rune(strings.ToLower(term))
+		if len(termRunes) == 0 {
+			continue
+		}
+		for i := 0; i+len(termRunes) <= len(lower); i++ {
+			if string(lower[i:i+len(termRunes)]) == string(termRunes) {
+				for j := i; j < i+len(termRunes); j++ {
+					marked[j] = true
+				}
+			}
+		}
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && marked[j] == marked[i] {
+			j++
+		}
+		segment := html.EscapeString(string(runes[i:j]))
+		if marked[i] {
+			sb.WriteString("<em>" + segment + "</em>")
+		} else {
+			sb.WriteString(segment)
+		}
+		i = j
+	}
+	return sb.String()
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"errors"
+	"log"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/pkg/infra"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultStarredLimit 未指定时每页返回的收藏数
+const defaultStarredLimit = 20
+
+// StarMessage 收藏或取消收藏消息
+// 收藏状态保存在用户自己的 message_users 记录中，还没有记录时创建
+func StarMessage(userID, messageID uint64, starred bool) error {
+	db := infra.GetDB()
+	var msg model.Message
+	err := db.Select("conversation_id, status").
+		Where("id = ?", messageID).
+		Take(&msg).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("消息不存在")
+		}
+		log.Println(err)
+		return errors.New("服务器错误")
+	}
+	if starred && (msg.Status == model.RECALLED || msg.Status == model.SYSTEM) {
+		return errors.New("该消息不能收藏")
+	}
+	err = sendMessageAuth(userID, msg.ConversationID)
+	if err != nil {
+		return err
+	}
+
+	// 用户删除过的消息记录是软删除的，冲突时不更新，影响行数为 0
+	res := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "message_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"is_starred", "updated_at"}),
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "message_users.deleted_at IS NULL"}}},
+	}).Create(&model.MessageUser{
+		UserID:    userID,
+		MessageID: messageID,
+		IsStarred: starred,
+	})
+	if res.Error != nil {
+		log.Println(res.Error)
+		return errors.New("服务器错误")
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("消息不存在")
+	}
+	return nil
+}
+
+// StarredMessages 列出用户在所有会话中收藏的消息，传了 q 时只在收藏中搜索
+// 收藏后被撤回或被自己删除的消息不再出现，结果按消息ID从新到旧排列，用 before 游标翻页
+func StarredMessages(userID uint64, req model.StarredMessageReq) (*model.StarredMessagePageResp, error) {
+	db := infra.GetDB()
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultStarredLimit
+	}
+
+	sql := `SELECT ` + chatMessageColumns + `,
+			c.type AS conversation_type,
+			CASE
+			WHEN cu.remark != '' THEN cu.remark
+			WHEN c.type = ? THEN c.name
+			ELSE (SELECT ou.name FROM conversation_users ocu
+				JOIN users ou ON ou.id = ocu.user_id
+				WHERE ocu.conversation_id = m.conversation_id AND ocu.user_id != ?
+				LIMIT 1)
+			END AS conversation_name,
+			mu.updated_at AS starred_at` + chatMessageFrom + `
+			JOIN message_users mu ON mu.message_id = m.id AND mu.user_id = ?
+			JOIN conversation_users cu ON cu.conversation_id = m.conversation_id AND cu.user_id = ?
+			LEFT JOIN conversations c ON c.id = m.conversation_id
+			WHERE mu.is_starred AND mu.deleted_at IS NULL AND m.status NOT IN (?, ?)`
+	args := append(chatMessageArgs(), model.GROUP, userID, userID, userID, model.RECALLED, model.SYSTEM)
+
+	if req.Q != "" {
+		pattern := "%" + escapeLike(req.Q) + "%"
+		sql += ` AND (
+				(m.status = ? AND (t.text_tsv @@ websearch_to_tsquery('simple', ?) OR t.text ILIKE ?))
+				OR (m.status = ? AND (f.file_name_tsv @@ websearch_to_tsquery('simple', ?) OR f.file_name ILIKE ?))
+			)`
+		args = append(args, model.TEXT, req.Q, pattern, model.FILE, req.Q, pattern)
+	}
+	if req.ConversationID > 0 {
+		sql += ` AND m.conversation_id = ?`
+		args = append(args, req.ConversationID)
+	}
+	if req.Before > 0 {
+		sql += ` AND m.id < ?`
+		args = append(args, req.Before)
+	}
+	sql += ` ORDER BY m.id DESC LIMIT ?`
+	args = append(args, limit+1)
+
+	messages := make([]model.StarredMessageResp, 0, limit+1)
+	res := db.Raw(sql, args...).Scan(&messages)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return nil, errors.New("服务器错误")
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	terms := searchTerms(req.Q)
+	views := make([]*model.ChatHistoryResp, 0, len(messages))
+	for i := range messages {
+		if len(terms) > 0 {
+			messages[i].Highlight = highlight(matchedText(&messages[i].ChatHistoryResp), terms)
+		}
+		views = append(views, &messages[i].ChatHistoryResp)
+	}
+	if err := attachReactions(userID, views); err != nil {
+		return nil, err
+	}
+
+	nextCursor := req.Before
+	if len(messages) > 0 {
+		nextCursor = messages[len(messages)-1].MessageID
+	}
+
+	return &model.StarredMessagePageResp{
+		Messages:   messages,
+		NextCursor: nextCursor,
+		HasMore:    hasMore,
+	}, nil
+}
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/pkg/infra"
+	"github.com/lojes7/inquire/pkg/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultSyncLimit 未指定时一次同步返回的最大消息数
+const defaultSyncLimit = 200
+
+// Sync 离线同步
+// 返回游标之后用户所有会话中的新消息、撤回、编辑、表情回应、删除和会话变化
+// 游标是雪花ID，消息按ID比较，其它变化按游标中的时间比较
+func Sync(userID uint64, req model.SyncReq) (*model.SyncResp, error) {
+	db := infra.GetDB()
+
+	since := req.Since
+	if since == 0 {
+		var err error
+		since, err = getSyncCursor(db, userID, req.DeviceID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultSyncLimit
+	}
+
+	// 先确定本次同步的上界，之后发生的变化留给下一次同步
+	// 上界落后当前时间一小段，保证游标之前的消息都已经提交，见 stableMessageID
+	// 客户端传入的游标可能来自刚推送的新消息，超过上界时退回到上界，重复的消息由客户端按ID去重
+	until := stableMessageID()
+	if since > until {
+		since = until
+	}
+
+	messages := make([]model.MessageEventResp, 0)
+	sql := chatMessageSelect + `
+			JOIN conversation_users cu ON cu.conversation_id = m.conversation_id AND cu.user_id = ?
+			LEFT JOIN message_users mu ON mu.message_id = m.id AND mu.user_id = ?
+			WHERE m.id > ? AND m.id <= ? AND m.status != ? AND mu.deleted_at IS NULL
+			ORDER BY m.id ASC
+			LIMIT ?`
+	args := append(chatMessageArgs(), userID, userID, since, until, model.RECALLED, limit+1)
+	res := db.Raw(sql, args...).Scan(&messages)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return nil, errors.New("服务器错误")
+	}
+
+	// 消息太多时缩小上界，其它变化也只同步到最后一条消息为止
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+		until = messages[limit-1].MessageID
+	}
+	from, to := utils.TimeFromID(since), utils.TimeFromID(until)
+
+	recalled := make([]model.MessageRecalledEventResp, 0)
+	res = db.Raw(`SELECT m.conversation_id, m.id AS message_id
+			FROM messages m
+			JOIN conversation_users cu ON cu.conversation_id = m.conversation_id AND cu.user_id = ?
+			WHERE m.status = ? AND m.updated_at > ? AND m.updated_at <= ?
+			ORDER BY m.id ASC`,
+		userID, model.RECALLED, from, to).
+		Scan(&recalled)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return nil, errors.New("服务器错误")
+	}
+
+	// 游标之后的消息已经以最新内容出现在 messages 中，这里只需要之前的消息
+	edited := make([]model.MessageEventResp, 0)
+	sql = chatMessageSelect + `
+			JOIN conversation_users cu ON cu.conversation_id = m.conversation_id AND cu.user_id = ?
+			LEFT JOIN message_users mu ON mu.message_id = m.id AND mu.user_id = ?
+			WHERE m.id <= ? AND m.edited_at > ? AND m.edited_at <= ? AND m.status = ? AND mu.deleted_at IS NULL
+			ORDER BY m.id ASC`
+	args = append(chatMessageArgs(), userID, userID, since, from, to, model.TEXT)
+	res = db.Raw(sql, args...).Scan(&edited)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return nil, errors.New("服务器错误")
+	}
+
+	views := make([]*model.ChatHistoryResp, 0, len(messages)+len(edited))
+	for i := range messages {
+		views = append(views, &messages[i].ChatHistoryResp)
+	}
+	for i := range edited {
+		views = append(views, &edited[i].ChatHistoryResp)
+	}
+	err := attachReactions(userID, views)
+	if err != nil {
+		return nil, err
+	}
+
+	reactions, err := syncReactions(db, userID, since, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var deletedIDs []uint64
+	err = db.Unscoped().Model(&model.MessageUser{}).
+		Where("user_id = ? AND deleted_at > ? AND deleted_at <= ?", userID, from, to).
+		Order("message_id").
+		Pluck("message_id", &deletedIDs).Error
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+
+	// 隐藏的会话也要同步，所以不能排除软删除的记录
+	var members []model.ConversationUser
+	err = db.Unscoped().Model(&model.ConversationUser{}).
+		Where("user_id = ?", userID).
+		Order("conversation_id").
+		Find(&members).Error
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+
+	conversations := make([]model.SyncConversationResp, 0)
+	conversationIDs := make([]string, 0, len(members))
+	for _, cu := range members {
+		conversationIDs = append(conversationIDs, strconv.FormatUint(cu.ConversationID, 10))
+
+		changedAt := cu.UpdatedAt
+		if cu.DeletedAt.Valid && cu.DeletedAt.Time.After(changedAt) {
+			changedAt = cu.DeletedAt.Time
+		}
+		if cu.ReadAt != nil && cu.ReadAt.After(changedAt) {
+			changedAt = *cu.ReadAt
+		}
+		if !changedAt.After(from) || changedAt.After(to) {
+			continue
+		}
+		conversations = append(conversations, model.SyncConversationResp{
+			ConversationID:       cu.ConversationID,
+			Remark:               cu.Remark,
+			UnreadCount:          cu.UnreadCount,
+			Role:                 cu.Role,
+			Hidden:               cu.DeletedAt.Valid,
+			LastReadMessageID:    cu.LastReadMessageID,
+			ConversationSettings: conversationSettings(&cu, time.Now()),
+		})
+	}
+
+	err = saveSyncCursor(db, userID, req.DeviceID, until)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.SyncResp{
+		Messages:          messages,
+		Recalled:          recalled,
+		Edited:            edited,
+		Reactions:         reactions,
+		DeletedMessageIDs: formatIDs(deletedIDs),
+		Conversations:     conversations,
+		ConversationIDs:   conversationIDs,
+		NextCursor:        until,
+		HasMore:           hasMore,
+	}, nil
+}
+
+// syncReactions 查询游标之前的消息在 (from, to] 期间表情回应的变化，返回这些消息现在的回应
+// 取消的回应是软删除的，所以不能排除软删除的记录；游标之后的消息已经在 messages 中带上了回应
+func syncReactions(db *gorm.DB, userID, since uint64, from, to time.Time) ([]model.MessageReactionsResp, error) {
+	changed := make([]model.MessageReactionsResp, 0)
+	res := db.Raw(`SELECT DISTINCT m.conversation_id, m.id AS message_id
+			FROM message_reactions r
+			JOIN messages m ON m.id = r.message_id
+			JOIN conversation_users cu ON cu.conversation_id = m.conversation_id AND cu.user_id = ?
+			LEFT JOIN message_users mu ON mu.message_id = m.id AND mu.user_id = ?
+			WHERE m.id <= ? AND r.updated_at > ? AND r.updated_at <= ? AND m.status != ? AND mu.deleted_at IS NULL
+			ORDER BY m.id ASC`,
+		userID, userID, since, from, to, model.RECALLED).
+		Scan(&changed)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return nil, errors.New("服务器错误")
+	}
+	if len(changed) == 0 {
+		return changed, nil
+	}
+
+	messageIDs := make([]uint64, 0, len(changed))
+	for _, msg := range changed {
+		messageIDs = append(messageIDs, msg.MessageID)
+	}
+	reactions, err := messageReactions(userID, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range changed {
+		changed[i].Reactions = reactions[changed[i].MessageID]
+		if changed[i].Reactions == nil {
+			changed[i].Reactions = []model.ReactionResp{}
+		}
+	}
+	return changed, nil
+}
+
+// getSyncCursor 读取服务端为某台设备记录的同步游标，没有记录时返回0
+func getSyncCursor(db *gorm.DB, userID uint64, deviceID string) (uint64, error) {
+	var cursors []uint64
+	err := db.Model(&model.SyncCursor{}).
+		Where("user_id = ? AND device_id = ?", userID, deviceID).
+		Pluck("cursor", &cursors).Error
+	if err != nil {
+		log.Println(err)
+		return 0, errors.New("服务器错误")
+	}
+	if len(cursors) == 0 {
+		return 0, nil
+	}
+	return cursors[0], nil
+}
+
+// saveSyncCursor 保存某台设备的同步游标
+func saveSyncCursor(db *gorm.DB, userID uint64, deviceID string, cursor uint64) error {
+	sc := model.SyncCursor{
+		UserID:   userID,
+		DeviceID: deviceID,
+		Cursor:   cursor,
+	}
+	res := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "device_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"cursor", "updated_at"}),
+	}).Create(&sc)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return errors.New("服务器错误")
+	}
+	return nil
+}
+
+// formatIDs 把ID列表转换为字符串，避免前端丢失精度
+func formatIDs(ids []uint64) []string {
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, strconv.FormatUint(id, 10))
+	}
+	return result
+}
@@ -0,0 +1,494 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/pkg/infra"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// defaultChunkSize 未指定时的分片大小
+	defaultChunkSize = 5 << 20
+	// maxChunkCount 单个文件最多的分片数
+	maxChunkCount = 10000
+	// uploadTTL 上传会话的有效期，过期后未完成的分片会被清理
+	uploadTTL = 24 * time.Hour
+	// uploadCleanInterval 清理过期上传会话的间隔
+	uploadCleanInterval = time.Hour
+)
+
+// chunkKey 分片在存储中的 key
+func chunkKey(uploadID uint64, index int) string {
+	return fmt.Sprintf("uploads/%d/%d", uploadID, index)
+}
+
+// mergedKey 合并时写入的临时对象，校验通过后复制到 Blob 的 key
+func mergedKey(uploadID uint64) string {
+	return fmt.Sprintf("uploads/%d/merged", uploadID)
+}
+
+// CreateUpload 创建分片上传会话
+func CreateUpload(userID uint64, req model.CreateUploadReq) (*model.UploadSessionResp, error) {
+	err := sendMessageAuth(userID, req.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+	err = checkReplyTo(req.ConversationID, req.ReplyToMessageID)
+	if err != nil {
+		return nil, err
+	}
+	err = checkUploadSize(userID, req.ConversationID, req.FileSize, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	chunkSize := req.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = defaultChunkSize
+	}
+	chunkCount := int((req.FileSize + chunkSize - 1) / chunkSize)
+	if chunkCount > maxChunkCount {
+		return nil, errors.New("分片数量过多，请增大分片大小")
+	}
+
+	session := model.UploadSession{
+		UserID:           userID,
+		ConversationID:   req.ConversationID,
+		FileName:         req.FileName,
+		FileSize:         req.FileSize,
+		ChunkSize:        chunkSize,
+		ChunkCount:       chunkCount,
+		Checksum:         strings.ToLower(req.Checksum),
+		Status:           model.UPLOADING,
+		ExpiresAt:        time.Now().Add(uploadTTL),
+		ReplyToMessageID: req.ReplyToMessageID,
+	}
+	db := infra.GetDB()
+	res := db.Create(&session)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return nil, errors.New("服务器错误")
+	}
+
+	return uploadSessionResp(&session, []int{}), nil
+}
+
+// getUploadSession 获取用户自己的、未过期的上传会话
+func getUploadSession(db *gorm.DB, userID, uploadID uint64) (*model.UploadSession, error) {
+	var session model.UploadSession
+	err := db.Where("id = ? AND user_id = ?", uploadID, userID).First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("上传会话不存在")
+		}
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+	if session.Status != model.COMPLETED && time.Now().After(session.ExpiresAt) {
+		return nil, errors.New("上传会话已过期")
+	}
+	return &session, nil
+}
+
+// chunkSize 计算第 index 个分片应有的大小，最后一个分片可能较小
+func chunkSize(session *model.UploadSession, index int) int64 {
+	if index == session.ChunkCount-1 {
+		return session.FileSize - session.ChunkSize*int64(session.ChunkCount-1)
+	}
+	return session.ChunkSize
+}
+
+// UploadChunk 上传一个分片，同一分片重复上传会覆盖之前的内容
+// size 必须与该分片应有的大小一致，checksum 不为空时校验分片的 sha256
+func UploadChunk(userID, uploadID uint64, index int, r io.Reader, size int64, checksum string) (*model.UploadChunkResp, error) {
+	db := infra.GetDB()
+	session, err := getUploadSession(db, userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if session.Status != model.UPLOADING {
+		return nil, errors.New("上传已完成")
+	}
+	if index < 0 || index >= session.ChunkCount {
+		return nil, errors.New("分片序号错误")
+	}
+	if size != chunkSize(session, index) {
+		return nil, fmt.Errorf("分片大小应为 %d 字节", chunkSize(session, index))
+	}
+
+	// 第一个分片包含文件头，写入之前先按内容检查文件类型
+	if index == 0 {
+		head := make([]byte, min(512, size))
+		n, err := io.ReadFull(r, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			log.Println(err)
+			return nil, errors.New("服务器错误")
+		}
+		head = head[:n]
+		if err := checkUploadType(getFileType(head, session.FileName)); err != nil {
+			return nil, err
+		}
+		r = io.MultiReader(bytes.NewReader(head), r)
+	}
+
+	key := chunkKey(uploadID, index)
+	hasher := sha256.New()
+	counter := &countingReader{r: io.TeeReader(io.LimitReader(r, size), hasher)}
+	err = infra.GetStorage().Put(context.Background(), key, counter, size, "application/octet-stream")
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	if counter.n != size || (checksum != "" && !strings.EqualFold(checksum, sum)) {
+		if delErr := infra.GetStorage().Delete(context.Background(), key); delErr != nil {
+			log.Println(delErr)
+		}
+		if counter.n != size {
+			return nil, errors.New("分片不完整")
+		}
+		return nil, errors.New("分片校验失败")
+	}
+
+	chunk := model.UploadChunk{
+		UploadID:   uploadID,
+		ChunkIndex: index,
+		Size:       size,
+		Checksum:   sum,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// 锁住会话后重新检查状态，写入期间会话可能已经开始合并
+		// 合并中的分片不能删除，合并时按读到的内容计算 hash，被覆盖的内容会校验失败
+		var current model.UploadSession
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("status").
+			Where("id = ?", uploadID).
+			Take(&current).Error
+		if err != nil {
+			log.Println(err)
+			return errors.New("服务器错误")
+		}
+		if current.Status != model.UPLOADING {
+			return errors.New("上传已完成或正在合并")
+		}
+
+		res := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "upload_id"}, {Name: "chunk_index"}},
+			DoUpdates: clause.AssignmentColumns([]string{"size", "checksum", "updated_at"}),
+		}).Create(&chunk)
+		if res.Error != nil {
+			log.Println(res.Error)
+			return errors.New("服务器错误")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.UploadChunkResp{
+		ChunkIndex: index,
+		Size:       size,
+		Checksum:   sum,
+	}, nil
+}
+
+// UploadStatus 查询上传会话的状态和已上传的分片，用于断点续传
+func UploadStatus(userID, uploadID uint64) (*model.UploadSessionResp, error) {
+	db := infra.GetDB()
+	session, err := getUploadSession(db, userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	uploaded, err := uploadedChunks(db, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	return uploadSessionResp(session, uploaded), nil
+}
+
+func uploadedChunks(db *gorm.DB, uploadID uint64) ([]int, error) {
+	uploaded := make([]int, 0)
+	err := db.Model(&model.UploadChunk{}).
+		Where("upload_id = ?", uploadID).
+		Order("chunk_index").
+		Pluck("chunk_index", &uploaded).Error
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+	return uploaded, nil
+}
+
+// CompleteUpload 合并所有分片，校验整个文件的 sha256，然后发送文件消息
+// 校验失败时会话保持可上传状态，客户端可以重新上传分片后再次合并
+func CompleteUpload(userID, uploadID uint64) (*model.SendFileResp, error) {
+	db := infra.GetDB()
+	session, err := getUploadSession(db, userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 同一会话只允许一个合并操作，改为合并中之后不再接受分片
+	res := db.Model(&model.UploadSession{}).
+		Where("id = ? AND status = ?", uploadID, model.UPLOADING).
+		Update("status", model.COMPLETING)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return nil, errors.New("服务器错误")
+	}
+	if res.RowsAffected == 0 {
+		return nil, errors.New("上传已完成或正在合并")
+	}
+
+	resp, err := completeUpload(db, session)
+	if err != nil {
+		res = db.Model(&model.UploadSession{}).
+			Where("id = ?", uploadID).
+			Update("status", model.UPLOADING)
+		if res.Error != nil {
+			log.Println(res.Error)
+		}
+		return nil, err
+	}
+
+	res = db.Model(&model.UploadSession{}).
+		Where("id = ?", uploadID).
+		Updates(map[string]any{"status": model.COMPLETED, "message_id": resp.MessageID})
+	if res.Error != nil {
+		log.Println(res.Error)
+	}
+	go deleteUploadChunks(session)
+
+	return resp, nil
+}
+
+func completeUpload(db *gorm.DB, session *model.UploadSession) (*model.SendFileResp, error) {
+	uploaded, err := uploadedChunks(db, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(uploaded) != session.ChunkCount {
+		return nil, fmt.Errorf("还有 %d 个分片未上传", session.ChunkCount-len(uploaded))
+	}
+
+	// 合并前再确认一次，用户可能已经不在该会话中，配额也可能已经被其它文件占用
+	err = sendMessageAuth(session.UserID, session.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+	err = checkUploadSize(session.UserID, session.ConversationID, session.FileSize, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	// 分片只读一遍，边计算 sha256 边写入临时对象，校验通过后才复制到按 hash 命名的 key
+	// 避免客户端声称一个不属于自己的 hash 来获取或覆盖别人的文件
+	// 也避免两次读取之间分片被覆盖，使共享的内容与 hash 不一致
+	chunks := &chunkReader{ctx: ctx, uploadID: session.ID, count: session.ChunkCount}
+	defer chunks.Close()
+
+	// 读取前 512 字节用于检测文件类型
+	head := make([]byte, 512)
+	n, err := io.ReadFull(chunks, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+	head = head[:n]
+	fileType := getFileType(head, session.FileName)
+	if err := checkUploadType(fileType); err != nil {
+		return nil, err
+	}
+
+	tmpKey := mergedKey(session.ID)
+	defer func() {
+		if err := infra.GetStorage().Delete(ctx, tmpKey); err != nil {
+			log.Println(err)
+		}
+	}()
+	hasher := sha256.New()
+	body := io.TeeReader(io.MultiReader(bytes.NewReader(head), chunks), hasher)
+	err = infra.GetStorage().Put(ctx, tmpKey, body, session.FileSize, fileType)
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("服务器错误")
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != session.Checksum {
+		return nil, errors.New("文件校验失败，请重新上传")
+	}
+
+	blob, err := reserveBlob(session.Checksum, session.FileSize, fileType)
+	if err != nil {
+		return nil, err
+	}
+	if blob.RefCount == 0 {
+		err = copyObject(ctx, tmpKey, blob.StorageKey, session.FileSize, fileType)
+		if err != nil {
+			log.Println(err)
+			return nil, errors.New("服务器错误")
+		}
+	}
+
+	return createFileMessage(session.UserID, session.ConversationID, session.ReplyToMessageID,
+		session.FileName, blob)
+}
+
+// copyObject 把存储中 src 的内容复制到 dst
+func copyObject(ctx context.Context, src, dst string, size int64, contentType string) error {
+	r, _, err := infra.GetStorage().Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return infra.GetStorage().Put(ctx, dst, r, size, contentType)
+}
+
+// AbortUpload 取消上传，删除已上传的分片
+func AbortUpload(userID, uploadID uint64) error {
+	db := infra.GetDB()
+	session, err := getUploadSession(db, userID, uploadID)
+	if err != nil {
+		return err
+	}
+	if session.Status == model.COMPLETING {
+		return errors.New("正在合并，无法取消")
+	}
+
+	deleteUploadChunks(session)
+	res := db.Unscoped().Delete(&model.UploadSession{}, uploadID)
+	if res.Error != nil {
+		log.Println(res.Error)
+		return errors.New("服务器错误")
+	}
+	return nil
+}
+
+// deleteUploadChunks 删除会话在存储中的分片、合并的临时对象和分片记录
+func deleteUploadChunks(session *model.UploadSession) {
+	ctx := context.Background()
+	for i := 0; i < session.ChunkCount; i++ {
+		if err := infra.GetStorage().Delete(ctx, chunkKey(session.ID, i)); err != nil {
+			log.Println(err)
+		}
+	}
+	if err := infra.GetStorage().Delete(ctx, mergedKey(session.ID)); err != nil {
+		log.Println(err)
+	}
+
+	db := infra.GetDB()
+	res := db.Unscoped().Where("upload_id = ?", session.ID).Delete(&model.UploadChunk{})
+	if res.Error != nil {
+		log.Println(res.Error)
+	}
+}
+
+// CleanExpiredUploads 定期清理过期的上传会话，需要在单独的 goroutine 中运行
+func CleanExpiredUploads() {
+	ticker := time.NewTicker(uploadCleanInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		db := infra.GetDB()
+		var sessions []model.UploadSession
+		err := db.Where("expires_at < ? AND status != ?", time.Now(), model.COMPLETING).
+			Find(&sessions).Error
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		for i := range sessions {
+			deleteUploadChunks(&sessions[i])
+			res := db.Unscoped().Delete(&model.UploadSession{}, sessions[i].ID)
+			if res.Error != nil {
+				log.Println(res.Error)
+			}
+		}
+	}
+}
+
+func uploadSessionResp(session *model.UploadSession, uploaded []int) *model.UploadSessionResp {
+	return &model.UploadSessionResp{
+		UploadID:       session.ID,
+		FileName:       session.FileName,
+		FileSize:       session.FileSize,
+		ChunkSize:      session.ChunkSize,
+		ChunkCount:     session.ChunkCount,
+		UploadedChunks: uploaded,
+		Completed:      session.Status == model.COMPLETED,
+		MessageID:      session.MessageID,
+		ExpiresAt:      session.ExpiresAt,
+	}
+}
+
+// chunkReader 按序号依次读取所有分片，读完一个再打开下一个
+type chunkReader struct {
+	ctx      context.Context
+	uploadID uint64
+	count    int
+	next     int
+	cur      io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if r.next == r.count {
+				return 0, io.EOF
+			}
+			cur, _, err := infra.GetStorage().Get(r.ctx, chunkKey(r.uploadID, r.next))
+			if err != nil {
+				return 0, err
+			}
+			r.cur = cur
+			r.next++
+		}
+
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.cur != nil {
+		return r.cur.Close()
+	}
+	return nil
+}
+
+// countingReader 统计读取的字节数
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
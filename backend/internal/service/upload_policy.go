@@ -0,0 +1,197 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/lojes7/inquire/internal/model"
+	"github.com/lojes7/inquire/pkg/infra"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrFileTooLarge 文件超过单个文件的大小上限
+	ErrFileTooLarge = errors.New("文件过大")
+	// ErrUserQuotaExceeded 超过用户的存储配额
+	ErrUserQuotaExceeded = errors.New("个人存储空间不足")
+	// ErrConversationQuotaExceeded 超过会话的存储配额
+	ErrConversationQuotaExceeded = errors.New("该会话的存储空间不足")
+	// ErrFileTypeNotAllowed 文件类型不允许上传
+	ErrFileTypeNotAllowed = errors.New("不允许上传该类型的文件")
+)
+
+// checkUploadSize 检查文件大小以及用户和会话的存储配额
+// 用户的用量包含未完成的分片上传，exceptUploadID 为正在合并的上传会话，不重复计算
+func checkUploadSize(userID, conversationID uint64, size int64, exceptUploadID uint64) error {
+	policy := infra.GetUploadPolicy()
+	if policy.MaxFileSize > 0 && size > policy.MaxFileSize {
+		return ErrFileTooLarge
+	}
+
+	db := infra.GetDB()
+	err := checkUserQuota(db, userID, size, exceptUploadID)
+	if err != nil {
+		return err
+	}
+	return checkConversationQuota(db, conversationID, size)
+}
+
+// checkForwardQuota 检查把 size 字节的文件转发到多个会话后是否超过存储配额
+// 每个目标会话各增加一份，用户的用量按目标会话的数量累计
+func checkForwardQuota(userID uint64, conversationIDs []uint64, size int64) error {
+	if size == 0 {
+		return nil
+	}
+	db := infra.GetDB()
+	err := checkUserQuota(db, userID, size*int64(len(conversationIDs)), 0)
+	if err != nil {
+		return err
+	}
+	for _, conversationID := range conversationIDs {
+		err = checkConversationQuota(db, conversationID, size)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkUserQuota 检查用户再增加 size 字节后是否超过个人存储配额
+func checkUserQuota(db *gorm.DB, userID uint64, size int64, exceptUploadID uint64) error {
+	policy := infra.GetUploadPolicy()
+	if policy.UserQuota <= 0 {
+		return nil
+	}
+	used, _, err := senderFileUsage(db, "m.sender_id = ?", userID)
+	if err != nil {
+		return err
+	}
+	pending, err := pendingUploadBytes(db, userID, exceptUploadID)
+	if err != nil {
+		return err
+	}
+	if used+pending+size > policy.UserQuota {
+		return ErrUserQuotaExceeded
+	}
+	return nil
+}
+
+// checkConversationQuota 检查会话再增加 size 字节后是否超过会话的存储配额
+func checkConversationQuota(db *gorm.DB, conversationID uint64, size int64) error {
+	policy := infra.GetUploadPolicy()
+	if policy.ConversationQuota <= 0 {
+		return nil
+	}
+	used, _, err := senderFileUsage(db, "m.conversation_id = ?", conversationID)
+	if err != nil {
+		return err
+	}
+	if used+size > policy.ConversationQuota {
+		return ErrConversationQuotaExceeded
+	}
+	return nil
+}
+
+// checkUploadType 按内容检测出的文件类型检查允许和禁止列表，禁止列表优先
+func checkUploadType(fileType string) error {
+	policy := infra.GetUploadPolicy()
+	mediaType, _, err := mime.ParseMediaType(fileType)
+	if err != nil {
+		mediaType = strings.ToLower(fileType)
+	}
+
+	for _, pattern := range policy.DeniedTypes {
+		if matchType(pattern, mediaType) {
+			return ErrFileTypeNotAllowed
+		}
+	}
+	if len(policy.AllowedTypes) == 0 {
+		return nil
+	}
+	for _, pattern := range policy.AllowedTypes {
+		if matchType(pattern, mediaType) {
+			return nil
+		}
+	}
+	return ErrFileTypeNotAllowed
+}
+
+// matchType 判断文件类型是否匹配，pattern 支持 image/* 和 * 形式的通配
+func matchType(pattern, mediaType string) bool {
+	if pattern == "*" || pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(pattern, "/*")
+	return ok && strings.HasPrefix(mediaType, prefix+"/")
+}
+
+// isPolicyError 判断是否为上传限制导致的错误，这类错误需要原样返回给用户
+func isPolicyError(err error) bool {
+	return errors.Is(err, ErrFileTooLarge) || errors.Is(err, ErrUserQuotaExceeded) ||
+		errors.Is(err, ErrConversationQuotaExceeded) || errors.Is(err, ErrFileTypeNotAllowed)
+}
+
+// senderFileUsage 统计未撤回的文件消息和聊天记录中的文件的总大小和数量
+// 同一内容发送或转发多次按多次计算，与存储中是否去重无关
+func senderFileUsage(db *gorm.DB, cond string, arg uint64) (int64, int64, error) {
+	var usage struct {
+		Bytes int64
+		Count int64
+	}
+	err := db.Raw(`SELECT COALESCE(SUM(s.file_size), 0) AS bytes, COUNT(*) AS count
+			FROM (
+				SELECT f.file_size FROM files f
+				JOIN messages m ON m.id = f.message_id
+				WHERE m.status = ? AND `+cond+`
+				UNION ALL
+				SELECT ci.file_size FROM chat_record_items ci
+				JOIN messages m ON m.id = ci.record_id
+				WHERE m.status = ? AND ci.status = ? AND `+cond+`
+			) s`, model.FILE, arg, model.CHAT_RECORD, model.FILE, arg).
+		Scan(&usage).Error
+	if err != nil {
+		log.Println(err)
+		return 0, 0, errors.New("服务器错误")
+	}
+	return usage.Bytes, usage.Count, nil
+}
+
+// pendingUploadBytes 统计用户未完成、未过期的分片上传预占的大小
+func pendingUploadBytes(db *gorm.DB, userID, exceptUploadID uint64) (int64, error) {
+	var pending int64
+	err := db.Model(&model.UploadSession{}).
+		Select("COALESCE(SUM(file_size), 0)").
+		Where("user_id = ? AND status != ? AND expires_at > ? AND id != ?",
+			userID, model.COMPLETED, time.Now(), exceptUploadID).
+		Scan(&pending).Error
+	if err != nil {
+		log.Println(err)
+		return 0, errors.New("服务器错误")
+	}
+	return pending, nil
+}
+
+// StorageUsage 查询用户的存储用量和配额
+func StorageUsage(userID uint64) (*model.StorageUsageResp, error) {
+	db := infra.GetDB()
+	used, count, err := senderFileUsage(db, "m.sender_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := pendingUploadBytes(db, userID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := infra.GetUploadPolicy()
+	return &model.StorageUsageResp{
+		UsedBytes:    used,
+		FileCount:    count,
+		PendingBytes: pending,
+		QuotaBytes:   policy.UserQuota,
+		MaxFileSize:  policy.MaxFileSize,
+	}, nil
+}
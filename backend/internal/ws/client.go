@@ -1,12 +1,15 @@
 package ws
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/lojes7/inquire/pkg/utils"
 )
 
 const (
@@ -20,7 +23,11 @@ const (
 	pingPeriod = (pongWait * 9) / 10
 
 	// Maximum message size allowed from peer.
-	maxMessageSize = 512
+	// 需要能容纳一条最长的文本消息命令
+	maxMessageSize = 8192
+
+	// 设备标识的最大长度
+	maxDeviceIDLength = 64
 )
 
 var upgrader = websocket.Upgrader{
@@ -43,6 +50,9 @@ type Client struct {
 	Send chan []byte
 
 	UserID uint64
+
+	// 设备标识，同一用户的多个连接通过它区分
+	DeviceID string
 }
 
 // writePump pumps messages from the hub to the websocket connection.
@@ -62,19 +72,8 @@ func (c *Client) writePump() {
 				return
 			}
 
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			// Add queued chat messages to the current websocket message.
-			n := len(c.Send)
-			for i := 0; i < n; i++ {
-				w.Write(<-c.Send)
-			}
-
-			if err := w.Close(); err != nil {
+			// 每条事件单独作为一帧发送，客户端按帧解析 json
+			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -87,6 +86,7 @@ func (c *Client) writePump() {
 }
 
 // readPump pumps messages from the websocket connection to the hub.
+// 每一帧都会被当作命令处理，同一连接上的命令按顺序执行
 func (c *Client) readPump() {
 	defer func() {
 		c.Hub.unregister <- c
@@ -99,13 +99,14 @@ func (c *Client) readPump() {
 		return nil
 	})
 	for {
-		_, _, err := c.Conn.ReadMessage()
+		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("error: %v", err)
 			}
 			break
 		}
+		c.dispatch(message)
 	}
 }
 
@@ -113,6 +114,20 @@ func (c *Client) readPump() {
 func ServeWs(hub *Hub, c *gin.Context) {
 	userID := c.GetUint64("id")
 
+	// 客户端可以通过 device_id 参数带上自己的设备标识，重连时保持不变
+	// 没有带的话由服务端分配一个
+	deviceID := c.Query("device_id")
+	if len(deviceID) > maxDeviceIDLength {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": "device_id 过长",
+		})
+		return
+	}
+	if deviceID == "" {
+		deviceID = strconv.FormatUint(utils.NewUniqueID(), 10)
+	}
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Println(err)
@@ -120,12 +135,22 @@ func ServeWs(hub *Hub, c *gin.Context) {
 	}
 
 	client := &Client{
-		Hub:    hub,
-		Conn:   conn,
-		Send:   make(chan []byte, 256),
-		UserID: userID,
+		Hub:      hub,
+		Conn:     conn,
+		Send:     make(chan []byte, 256),
+		UserID:   userID,
+		DeviceID: deviceID,
 	}
 
+	// 连接建立后先告诉客户端自己的设备标识
+	msgBytes, _ := json.Marshal(Event{
+		Type: EventConnected,
+		Data: map[string]any{
+			"device_id": deviceID,
+		},
+	})
+	client.Send <- msgBytes
+
 	client.Hub.register <- client
 
 	go client.writePump()
@@ -0,0 +1,64 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// Command 客户端通过 websocket 发送给服务端的命令
+// ID 由客户端生成，服务端的 ack/error 回复会带上相同的 ID
+type Command struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// CommandHandler 处理一种命令，返回值会作为 ack 的 data 回复给客户端
+// 返回错误时回复 error，错误信息直接展示给客户端
+type CommandHandler func(client *Client, data json.RawMessage) (any, error)
+
+var commandHandlers = make(map[string]CommandHandler)
+
+// HandleCommand 注册一种命令的处理函数
+// 只能在服务启动、开始接受连接之前调用
+func HandleCommand(commandType string, handler CommandHandler) {
+	commandHandlers[commandType] = handler
+}
+
+// dispatch 解析并处理客户端发来的一帧数据
+func (c *Client) dispatch(frame []byte) {
+	var cmd Command
+	if err := json.Unmarshal(frame, &cmd); err != nil {
+		c.reply(EventError, "", errorData("命令格式错误"))
+		return
+	}
+
+	handler, ok := commandHandlers[cmd.Type]
+	if !ok {
+		c.reply(EventError, cmd.ID, errorData("不支持的命令类型"))
+		return
+	}
+
+	result, err := handler(c, cmd.Data)
+	if err != nil {
+		c.reply(EventError, cmd.ID, errorData(err.Error()))
+		return
+	}
+	c.reply(EventAck, cmd.ID, result)
+}
+
+// reply 回复当前连接
+func (c *Client) reply(eventType, id string, data any) {
+	msgBytes, err := json.Marshal(Event{Type: eventType, ID: id, Data: data})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	c.Hub.send(c, msgBytes)
+}
+
+func errorData(message string) map[string]any {
+	return map[string]any{
+		"message": message,
+	}
+}
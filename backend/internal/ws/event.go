@@ -0,0 +1,26 @@
+package ws
+
+// 推送给客户端的事件类型
+const (
+	EventAck              = "ack"
+	EventError            = "error"
+	EventConnected        = "connected"
+	EventNewFriendRequest = "new_friend_request"
+	EventNewMessage       = "new_message"
+	EventMessageRecalled  = "message_recalled"
+	EventMessageRead      = "message_read"
+	EventMessageEdited    = "message_edited"
+	EventMessageReaction  = "message_reaction"
+	EventFileScanned      = "file_scanned"
+	EventFileMedia        = "file_media"
+	EventPresence         = "presence"
+	EventTyping           = "typing"
+)
+
+// Event 服务端通过 websocket 推送给客户端的消息格式
+// ID 只在回复客户端命令时出现，与命令的 ID 相同
+type Event struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+	Data any    `json:"data"`
+}
@@ -5,9 +5,9 @@ import (
 )
 
 type Hub struct {
-	// 用户ID -> 连接列表 (考虑到多端登录，可以用切片，这里先简单点用单个)
-	// 为了简单，我们 assumption: 每个用户只有一个连接，或者发给所有连接
-	clients map[uint64]*Client
+	// 用户ID -> 该用户所有在线设备的连接
+	// 同一用户可以在多个设备（或多个标签页）上同时在线
+	clients map[uint64]map[*Client]bool
 
 	// Register requests from the clients.
 	register chan *Client
@@ -16,6 +16,9 @@ type Hub struct {
 	unregister chan *Client
 
 	rwMutex sync.RWMutex
+
+	// 用户上线（第一个连接建立）或下线（最后一个连接断开）时调用
+	onPresence func(userID uint64)
 }
 
 var hubInstance *Hub
@@ -24,7 +27,7 @@ var once sync.Once
 func GetHub() *Hub {
 	once.Do(func() {
 		hubInstance = &Hub{
-			clients:    make(map[uint64]*Client),
+			clients:    make(map[uint64]map[*Client]bool),
 			register:   make(chan *Client),
 			unregister: make(chan *Client),
 		}
@@ -38,39 +41,154 @@ func (h *Hub) run() {
 		select {
 		case client := <-h.register:
 			h.rwMutex.Lock()
-			// 如果已有连接，这里策略是顶掉旧的？或者只保留一个。
-			// 简单起见，覆盖。
-			if old, ok := h.clients[client.UserID]; ok {
-				close(old.Send)
-				delete(h.clients, client.UserID)
+			wasOnline := len(h.clients[client.UserID]) > 0
+			// 同一设备重连时顶掉旧连接，不同设备之间互不影响
+			for old := range h.clients[client.UserID] {
+				if old.DeviceID == client.DeviceID {
+					h.removeClient(old)
+				}
+			}
+			devices, ok := h.clients[client.UserID]
+			if !ok {
+				devices = make(map[*Client]bool)
+				h.clients[client.UserID] = devices
+			}
+			devices[client] = true
+			if !wasOnline {
+				h.notifyPresence(client.UserID)
 			}
-			h.clients[client.UserID] = client
 			h.rwMutex.Unlock()
 		case client := <-h.unregister:
 			h.rwMutex.Lock()
-			if _, ok := h.clients[client.UserID]; ok {
-				delete(h.clients, client.UserID)
-				close(client.Send)
+			if h.removeClient(client) {
+				h.notifyPresence(client.UserID)
 			}
 			h.rwMutex.Unlock()
 		}
 	}
 }
 
-// SendToUser 发送消息给指定用户
-func (h *Hub) SendToUser(userID uint64, message []byte) {
+// removeClient 移除一个连接并关闭其发送通道，返回该用户是否因此下线
+// 调用方必须持有写锁，重复移除同一个连接是安全的
+func (h *Hub) removeClient(client *Client) bool {
+	devices, ok := h.clients[client.UserID]
+	if !ok || !devices[client] {
+		return false
+	}
+	delete(devices, client)
+	close(client.Send)
+	if len(devices) == 0 {
+		delete(h.clients, client.UserID)
+		return true
+	}
+	return false
+}
+
+// notifyPresence 异步调用在线状态回调，避免阻塞 hub
+// 调用方必须持有锁
+func (h *Hub) notifyPresence(userID uint64) {
+	if h.onPresence != nil {
+		go h.onPresence(userID)
+	}
+}
+
+// OnPresenceChange 设置用户上线或下线时的回调
+// 回调是异步执行的，顺序不保证，回调中应通过 IsOnline 获取最新状态
+func (h *Hub) OnPresenceChange(fn func(userID uint64)) {
+	h.rwMutex.Lock()
+	h.onPresence = fn
+	h.rwMutex.Unlock()
+}
+
+// IsOnline 判断用户当前是否至少有一个在线连接
+func (h *Hub) IsOnline(userID uint64) bool {
+	h.rwMutex.RLock()
+	defer h.rwMutex.RUnlock()
+	return len(h.clients[userID]) > 0
+}
+
+// send 向单个连接发送消息，发送缓冲区满时断开该连接
+func (h *Hub) send(client *Client, message []byte) {
 	h.rwMutex.RLock()
-	client, ok := h.clients[userID]
+	ok := h.trySend(client, message)
 	h.rwMutex.RUnlock()
+	if !ok {
+		h.evict([]*Client{client})
+	}
+}
 
-	if ok {
-		select {
-		case client.Send <- message:
-		default:
-			close(client.Send)
-			h.rwMutex.Lock()
-			delete(h.clients, userID)
-			h.rwMutex.Unlock()
+// trySend 不阻塞地把消息放入连接的发送缓冲区，缓冲区满时返回 false
+// 调用方必须持有读锁：发送通道只会在持有写锁时由 removeClient 关闭，
+// 持有读锁并确认连接仍然存在后再发送，才不会向已关闭的通道发送而 panic
+// 连接已经被移除时直接丢弃消息
+func (h *Hub) trySend(client *Client, message []byte) bool {
+	if !h.clients[client.UserID][client] {
+		return true
+	}
+	select {
+	case client.Send <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// evict 断开发送缓冲区已满的连接
+func (h *Hub) evict(clients []*Client) {
+	if len(clients) == 0 {
+		return
+	}
+	h.rwMutex.Lock()
+	defer h.rwMutex.Unlock()
+	for _, client := range clients {
+		if h.removeClient(client) {
+			h.notifyPresence(client.UserID)
+		}
+	}
+}
+
+// userClients 返回某个用户当前所有连接的快照
+func (h *Hub) userClients(userID uint64) []*Client {
+	h.rwMutex.RLock()
+	defer h.rwMutex.RUnlock()
+
+	devices := h.clients[userID]
+	result := make([]*Client, 0, len(devices))
+	for client := range devices {
+		result = append(result, client)
+	}
+	return result
+}
+
+// SendToUser 发送消息给指定用户的所有在线设备
+func (h *Hub) SendToUser(userID uint64, message []byte) {
+	h.sendWhere(userID, message, func(*Client) bool { return true })
+}
+
+// SendToDevice 发送消息给指定用户的某一台设备
+func (h *Hub) SendToDevice(userID uint64, deviceID string, message []byte) {
+	h.sendWhere(userID, message, func(client *Client) bool { return client.DeviceID == deviceID })
+}
+
+// sendWhere 在读锁内向用户满足条件的连接发送消息，之后再断开缓冲区已满的连接
+func (h *Hub) sendWhere(userID uint64, message []byte, match func(*Client) bool) {
+	var slow []*Client
+	h.rwMutex.RLock()
+	for client := range h.clients[userID] {
+		if match(client) && !h.trySend(client, message) {
+			slow = append(slow, client)
 		}
 	}
+	h.rwMutex.RUnlock()
+	h.evict(slow)
+}
+
+// DeviceIDs 返回指定用户当前所有在线设备的标识
+func (h *Hub) DeviceIDs(userID uint64) []string {
+	clients := h.userClients(userID)
+	result := make([]string, 0, len(clients))
+	for _, client := range clients {
+		result = append(result, client.DeviceID)
+	}
+	return result
 }
@@ -0,0 +1,18 @@
+package embedding
+
+import (
+	"context"
+	"errors"
+)
+
+// Dimension 向量维度，与 files.content_vector 的 vector(1536) 一致
+const Dimension = 1536
+
+// ErrEmptyText 没有可以用来计算向量的内容
+var ErrEmptyText = errors.New("没有可用于计算向量的内容")
+
+// Embedder 把一段文本转换为向量
+// 返回的向量长度必须为 Dimension
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
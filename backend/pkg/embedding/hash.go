@@ -0,0 +1,90 @@
+package embedding
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// HashEmbedder 基于特征哈希的本地向量实现
+// 不依赖外部服务，相同文本总是得到相同向量，适合本地开发和测试
+// 只能反映字面上的相似度，不理解语义
+type HashEmbedder struct{}
+
+func NewHashEmbedder() *HashEmbedder {
+	return &HashEmbedder{}
+}
+
+func (e *HashEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return nil, ErrEmptyText
+	}
+
+	vec := make([]float32, Dimension)
+	for _, token := range tokens {
+		h := fnv.New64a()
+		h.Write([]byte(token))
+		sum := h.Sum64()
+
+		// 用最高位决定正负，减少哈希冲突带来的偏差
+		idx := sum % Dimension
+		if sum>>63 == 1 {
+			vec[idx]--
+		} else {
+			vec[idx]++
+		}
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return nil, ErrEmptyText
+	}
+	norm = math.Sqrt(norm)
+	for i := range vec {
+		vec[i] = float32(float64(vec[i]) / norm)
+	}
+	return vec, nil
+}
+
+// tokenize 把文本切分为词
+// 字母和数字按连续片段切分并转为小写，汉字等没有空格的文字切为单字和相邻两字
+func tokenize(text string) []string {
+	tokens := make([]string, 0)
+	var word strings.Builder
+	var prevHan rune
+
+	flushWord := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+			unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r):
+			flushWord()
+			tokens = append(tokens, string(r))
+			if prevHan != 0 {
+				tokens = append(tokens, string([]rune{prevHan, r}))
+			}
+			prevHan = r
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(unicode.ToLower(r))
+		default:
+			flushWord()
+		}
+		prevHan = 0
+	}
+	flushWord()
+
+	return tokens
+}
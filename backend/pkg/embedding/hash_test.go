@@ -0,0 +1,112 @@
+package embedding
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello, World 2024!", []string{"hello", "world", "2024"}},
+		{"you're", []string{"you", "re"}},
+		{"你好世界", []string{"你", "好", "你好", "世", "好世", "界", "世界"}},
+		{"AI助手v2", []string{"ai", "助", "手", "助手", "v2"}},
+		// 被其它字符隔开的汉字不组成两字词
+		{"你 好", []string{"你", "好"}},
+		{"  ,.!? ", []string{}},
+	}
+	for _, tt := range tests {
+		if got := tokenize(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// TestHashEmbedderGolden 固定几个输入的结果，哈希或归一化方式变化时已保存的向量会失效
+func TestHashEmbedderGolden(t *testing.T) {
+	tests := []struct {
+		text string
+		// 非零的分量
+		want map[int]float64
+	}{
+		// fnv64a("hello") 最高位为 1，取负
+		{"hello world", map[int]float64{267: -1 / math.Sqrt2, 755: 1 / math.Sqrt2}},
+		{"你好", map[int]float64{350: 1 / math.Sqrt(3), 838: 1 / math.Sqrt(3), 675: 1 / math.Sqrt(3)}},
+		// 重复的词累加
+		{"world world hello", map[int]float64{267: -1 / math.Sqrt(5), 755: 2 / math.Sqrt(5)}},
+	}
+	for _, tt := range tests {
+		vec, err := NewHashEmbedder().Embed(t.Context(), tt.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(vec) != Dimension {
+			t.Fatalf("Embed(%q) has %d dimensions, want %d", tt.text, len(vec), Dimension)
+		}
+		for i, v := range vec {
+			if math.Abs(float64(v)-tt.want[i]) > 1e-6 {
+				t.Errorf("Embed(%q)[%d] = %v, want %v", tt.text, i, v, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestHashEmbedderDeterministic(t *testing.T) {
+	e := NewHashEmbedder()
+	texts := []string{
+		"季度销售报告 Q3 2024",
+		"The quick brown fox jumps over the lazy dog",
+	}
+	for _, text := range texts {
+		first, err := e.Embed(t.Context(), text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := NewHashEmbedder().Embed(t.Context(), text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(first, second) {
+			t.Errorf("Embed(%q) is not deterministic", text)
+		}
+
+		var norm float64
+		for _, v := range first {
+			norm += float64(v) * float64(v)
+		}
+		if math.Abs(norm-1) > 1e-5 {
+			t.Errorf("Embed(%q) norm² = %v, want 1", text, norm)
+		}
+	}
+}
+
+// TestHashEmbedderEquivalent 大小写、标点和词序不影响结果
+func TestHashEmbedderEquivalent(t *testing.T) {
+	e := NewHashEmbedder()
+	want, err := e.Embed(t.Context(), "hello world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"Hello, World!", "world hello", "  HELLO\tworld\n"} {
+		got, err := e.Embed(t.Context(), text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("Embed(%q) differs from Embed(%q)", text, "hello world")
+		}
+	}
+}
+
+func TestHashEmbedderEmpty(t *testing.T) {
+	for _, text := range []string{"", "   ", "…!?,.", "🙂"} {
+		if _, err := NewHashEmbedder().Embed(t.Context(), text); !errors.Is(err, ErrEmptyText) {
+			t.Errorf("Embed(%q) error = %v, want ErrEmptyText", text, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package embedding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxInputRunes 单次请求最多发送的字符数，超出部分直接截断
+const maxInputRunes = 8000
+
+// OpenAIEmbedder 调用 OpenAI 兼容的 /embeddings 接口
+// 自建的兼容服务（如 vLLM、Ollama）同样可以使用
+type OpenAIEmbedder struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *http.Client
+}
+
+func NewOpenAIEmbedder(baseURL, apiKey, model string) *OpenAIEmbedder {
+	return &OpenAIEmbedder{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		model:   model,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type openAIRequest struct {
+	Model      string `json:"model"`
+	Input      string `json:"input"`
+	Dimensions int    `json:"dimensions"`
+}
+
+type openAIResponse struct {
+	Data []struct {
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, ErrEmptyText
+	}
+	if runes := []rune(text); len(runes) > maxInputRunes {
+		text = string(runes[:maxInputRunes])
+	}
+
+	body, err := json.Marshal(openAIRequest{
+		Model:      e.model,
+		Input:      text,
+		Dimensions: Dimension,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.baseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result openAIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析向量接口返回失败: %w", err)
+	}
+	if result.Error != nil {
+		return nil, errors.New(result.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("向量接口返回状态码 %d", resp.StatusCode)
+	}
+	if len(result.Data) == 0 || len(result.Data[0].Embedding) != Dimension {
+		return nil, fmt.Errorf("向量接口返回的维度不是 %d", Dimension)
+	}
+	return result.Data[0].Embedding, nil
+}
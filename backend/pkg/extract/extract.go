@@ -0,0 +1,65 @@
+package extract
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxReadBytes 最多读取的文件大小，超出部分不参与提取
+const MaxReadBytes = 32 << 20
+
+// ErrUnsupported 文件类型不支持提取文本
+var ErrUnsupported = errors.New("不支持提取该类型文件的文本")
+
+// textExts 按纯文本处理的扩展名
+var textExts = map[string]bool{
+	".txt":      true,
+	".csv":      true,
+	".md":       true,
+	".markdown": true,
+}
+
+// Supported 判断是否可以从该文件中提取文本
+// fileName 用于取扩展名，mimeType 为 http.DetectContentType 的结果
+func Supported(fileName, mimeType string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	return textExts[ext] || ext == ".pdf" ||
+		strings.HasPrefix(mimeType, "text/plain") || strings.HasPrefix(mimeType, "application/pdf")
+}
+
+// Text 从文件中提取纯文本
+func Text(r io.Reader, fileName, mimeType string) (string, error) {
+	if !Supported(fileName, mimeType) {
+		return "", ErrUnsupported
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, MaxReadBytes))
+	if err != nil {
+		return "", err
+	}
+
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if ext == ".pdf" || strings.HasPrefix(mimeType, "application/pdf") {
+		return PDFText(data)
+	}
+	return plainText(data), nil
+}
+
+// plainText 把文本文件内容转为合法的 UTF-8，去掉 BOM 和 NUL
+func plainText(data []byte) string {
+	s := strings.TrimPrefix(string(data), "\uFEFF")
+	s = strings.ToValidUTF8(s, "")
+	s = strings.ReplaceAll(s, "\x00", "")
+	return strings.TrimSpace(s)
+}
+
+// Truncate 按字符截断文本
+func Truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
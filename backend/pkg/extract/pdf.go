@@ -0,0 +1,214 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// streamPattern 匹配 PDF 中的 stream 对象，第一组为字典，第二组为数据
+var streamPattern = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n`)
+
+// PDFText 从 PDF 中提取文本
+// 只解析内容流中 Tj、TJ、'、" 操作符里的字面量字符串，支持未压缩和 FlateDecode 压缩的流
+// 使用自定义编码（如 CID 字体）的文本无法还原，会被忽略
+func PDFText(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("%PDF")) {
+		return "", errors.New("不是有效的 PDF 文件")
+	}
+
+	var sb strings.Builder
+	for _, loc := range streamPattern.FindAllSubmatchIndex(data, -1) {
+		dict := data[loc[2]:loc[3]]
+		start := loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		raw := data[start : start+end]
+
+		// 图片、字体等其它过滤器的流不包含文本
+		if bytes.Contains(dict, []byte("/Subtype")) || bytes.Contains(dict, []byte("/Length1")) {
+			continue
+		}
+		content := raw
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			decoded, err := inflate(raw)
+			if err != nil {
+				continue
+			}
+			content = decoded
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			continue
+		}
+
+		parseContentStream(content, &sb)
+	}
+
+	return strings.TrimSpace(strings.ToValidUTF8(sb.String(), "")), nil
+}
+
+// inflate 解压 FlateDecode 流，截断的流尽量返回已解压的部分
+func inflate(raw []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	out, err := io.ReadAll(io.LimitReader(zr, MaxReadBytes))
+	if len(out) > 0 {
+		return out, nil
+	}
+	return nil, err
+}
+
+// parseContentStream 扫描内容流，把文本操作符中的字符串写入 sb
+// BT/ET 之间的文本块之间用换行分隔，TJ 中较大的字距调整视为空格
+func parseContentStream(content []byte, sb *strings.Builder) {
+	var pending []string
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, next := readLiteral(content, i)
+			pending = append(pending, s)
+			i = next
+		case c == '[':
+			// TJ 数组：字符串与字距调整交替出现
+			j := i + 1
+			var parts strings.Builder
+			for j < len(content) && content[j] != ']' {
+				switch {
+				case content[j] == '(':
+					s, next := readLiteral(content, j)
+					parts.WriteString(s)
+					j = next
+				case content[j] == '-' || (content[j] >= '0' && content[j] <= '9'):
+					k := j
+					for k < len(content) && (content[k] == '-' || content[k] == '.' || (content[k] >= '0' && content[k] <= '9')) {
+						k++
+					}
+					// 以千分之一字号为单位，左移超过 100 视为单词间距
+					if v, err := strconv.ParseFloat(string(content[j:k]), 64); err == nil && v <= -100 {
+						parts.WriteByte(' ')
+					}
+					j = k
+				default:
+					j++
+				}
+			}
+			pending = append(pending, parts.String())
+			i = j + 1
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case isRegular(c):
+			j := i
+			for j < len(content) && isRegular(content[j]) {
+				j++
+			}
+			op := string(content[i:j])
+			switch op {
+			case "Tj", "TJ":
+				for _, s := range pending {
+					sb.WriteString(s)
+				}
+			case "'", `"`, "T*":
+				sb.WriteByte('\n')
+				for _, s := range pending {
+					sb.WriteString(s)
+				}
+			case "Td", "TD":
+				sb.WriteByte(' ')
+			case "ET":
+				sb.WriteByte('\n')
+			}
+			if op != "" && !isOperand(op) {
+				pending = pending[:0]
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+}
+
+// readLiteral 读取从 start 开始的字面量字符串，返回解码后的内容和结束后的位置
+func readLiteral(content []byte, start int) (string, int) {
+	var buf []byte
+	depth := 0
+	i := start
+	for ; i < len(content); i++ {
+		c := content[i]
+		switch c {
+		case '(':
+			if depth > 0 {
+				buf = append(buf, c)
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return string(buf), i + 1
+			}
+			buf = append(buf, c)
+		case '\\':
+			i++
+			if i >= len(content) {
+				break
+			}
+			e := content[i]
+			switch e {
+			case 'n':
+				buf = append(buf, '\n')
+			case 'r':
+				buf = append(buf, '\r')
+			case 't':
+				buf = append(buf, '\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// 续行
+			default:
+				if e >= '0' && e <= '7' {
+					v := 0
+					k := 0
+					for ; k < 3 && i+k < len(content) && content[i+k] >= '0' && content[i+k] <= '7'; k++ {
+						v = v*8 + int(content[i+k]-'0')
+					}
+					i += k - 1
+					buf = append(buf, byte(v))
+				} else {
+					buf = append(buf, e)
+				}
+			}
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return string(buf), i
+}
+
+// isRegular 判断是否为 PDF 的普通字符（非空白、非分隔符）
+func isRegular(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0, '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return false
+	}
+	return true
+}
+
+// isOperand 判断 token 是否为数字操作数
+func isOperand(token string) bool {
+	for _, c := range token {
+		if c != '-' && c != '.' && c != '+' && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
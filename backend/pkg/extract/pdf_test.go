@@ -0,0 +1,201 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// pdfStream 生成一个 stream 对象，dict 为字典中除 /Length 以外的内容
+func pdfStream(dict string, data []byte) string {
+	return fmt.Sprintf("<< /Length %d %s>>\nstream\n%s\nendstream\n", len(data), dict, data)
+}
+
+// pdfFile 把 stream 对象拼成一个最简单的 PDF
+func pdfFile(streams ...string) []byte {
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	for i, s := range streams {
+		fmt.Fprintf(&b, "%d 0 obj\n%sendobj\n", i+1, s)
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return []byte(b.String())
+}
+
+func deflate(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadLiteral(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", `(Hello)`, "Hello"},
+		{"escaped parens", `(a\(b\)c)`, "a(b)c"},
+		{"balanced parens", `(f(x) = (y))`, "f(x) = (y)"},
+		{"control escapes", `(a\nb\rc\td)`, "a\nb\rc\td"},
+		{"ignored escapes", `(a\bb\fc)`, "abc"},
+		{"backslash", `(C:\\dir)`, `C:\dir`},
+		{"unknown escape", `(\q)`, "q"},
+		{"octal", `(\101\102C)`, "ABC"},
+		{"short octal", `(\7x)`, "\x07x"},
+		// 八进制最多三位，之后的数字是普通字符
+		{"long octal", `(\0053)`, "\x053"},
+		{"line continuation", "(long \\\nline)", "long line"},
+		{"crlf continuation", "(long \\\r\nline)", "long \nline"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, next := readLiteral([]byte(tt.in+" Tj"), 0)
+			if got != tt.want {
+				t.Errorf("readLiteral(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if next != len(tt.in) {
+				t.Errorf("readLiteral(%q) ends at %d, want %d", tt.in, next, len(tt.in))
+			}
+		})
+	}
+}
+
+// TestReadLiteralUnterminated 没有结束括号时读到内容末尾
+func TestReadLiteralUnterminated(t *testing.T) {
+	got, next := readLiteral([]byte(`(abc\)`), 0)
+	if got != "abc)" || next != 6 {
+		t.Errorf("readLiteral = %q, %d, want %q, 6", got, next, "abc)")
+	}
+}
+
+func TestPDFText(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"Tj", "BT /F1 12 Tf 72 712 Td (Hello World) Tj ET", "Hello World"},
+		{"escapes", `BT (Price: \(USD\) 5\045) Tj ET`, "Price: (USD) 5%"},
+		// 绝对值较大的字距调整视为空格，较小的只是字间距
+		{"TJ kerning", "BT [(Hel) -20 (lo) -250 (Wor) 120 (ld)] TJ ET", "Hello World"},
+		{"TJ decimal kerning", "BT [(a) -33.5 (b) -100.0 (c) +20 (d)] TJ ET", "ab cd"},
+		{"quote operators", `BT (Line1) Tj (Line2) ' 0 0 (Line3) " ET`, "Line1\nLine2\nLine3"},
+		{"T star", "BT (One) Tj T* (Two) Tj ET", "One\nTwo"},
+		{"text blocks", "BT (First) Tj ET\nBT (Second) Tj ET", "First\nSecond"},
+		{"Td", "BT (A) Tj 10 0 Td (B) Tj ET", "A B"},
+		// 不是文本操作符的字符串不输出
+		{"non-text operands", "BT (ignored) 1 0 0 1 0 0 cm (kept) Tj ET", "kept"},
+		{"comment", "BT\n% (comment) Tj\n(text) Tj ET", "text"},
+		{"invalid utf-8", `BT (caf\351) Tj ET`, "caf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PDFText(pdfFile(pdfStream("", []byte(tt.content))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("PDFText = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPDFTextFlateDecode(t *testing.T) {
+	compressed := deflate(t, "BT [(Com) -15 (pressed) -300 (text)] TJ ET")
+	pdf := pdfFile(
+		pdfStream("/Filter /FlateDecode ", compressed),
+		pdfStream("", []byte("BT (plain) Tj ET")),
+	)
+	got, err := PDFText(pdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Compressed text\nplain"; got != want {
+		t.Errorf("PDFText = %q, want %q", got, want)
+	}
+}
+
+// TestPDFTextTruncatedFlate 截断的压缩流返回已经解压出的部分
+func TestPDFTextTruncatedFlate(t *testing.T) {
+	var content strings.Builder
+	for i := range 2000 {
+		fmt.Fprintf(&content, "BT (Line %d) Tj ET\n", i)
+	}
+	compressed := deflate(t, content.String())
+	got, err := PDFText(pdfFile(pdfStream("/Filter /FlateDecode ", compressed[:len(compressed)/2])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got, "Line 0\nLine 1\n") || strings.Contains(got, "Line 1999") {
+		t.Errorf("PDFText of truncated stream = %.40q..., want a prefix of the lines", got)
+	}
+}
+
+func TestPDFTextSkipsStreams(t *testing.T) {
+	text := []byte("BT (hidden) Tj ET")
+	pdf := pdfFile(
+		pdfStream("/Type /XObject /Subtype /Image /Width 1 /Height 1 ", text),
+		pdfStream("/Length1 100 ", text),
+		pdfStream("/Filter /DCTDecode ", text),
+		pdfStream("/Filter /FlateDecode ", []byte("not zlib data")),
+		pdfStream("", []byte("BT (visible) Tj ET")),
+	)
+	got, err := PDFText(pdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "visible" {
+		t.Errorf("PDFText = %q, want %q", got, "visible")
+	}
+}
+
+func TestPDFTextInvalid(t *testing.T) {
+	if _, err := PDFText([]byte("hello (world) Tj")); err == nil {
+		t.Error("PDFText of non-PDF data should fail")
+	}
+	got, err := PDFText([]byte("%PDF-1.7\n%%EOF\n"))
+	if err != nil || got != "" {
+		t.Errorf("PDFText of PDF without streams = %q, %v", got, err)
+	}
+}
+
+func TestText(t *testing.T) {
+	pdf := pdfFile(pdfStream("", []byte("BT (from pdf) Tj ET")))
+	tests := []struct {
+		name     string
+		data     []byte
+		fileName string
+		mimeType string
+		want     string
+	}{
+		{"pdf by ext", pdf, "a.PDF", "application/octet-stream", "from pdf"},
+		{"pdf by type", pdf, "a", "application/pdf", "from pdf"},
+		{"text with bom", []byte("\uFEFF  note\x00s\n"), "a.md", "text/plain; charset=utf-8", "notes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Text(bytes.NewReader(tt.data), tt.fileName, tt.mimeType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Text = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Text(bytes.NewReader(pdf), "a.docx", "application/zip"); err != ErrUnsupported {
+		t.Errorf("Text of docx error = %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,19 @@
+package infra
+
+import "time"
+
+// defaultEditWindow 未配置 MESSAGE_EDIT_WINDOW 时消息发送后可以编辑的时长
+const defaultEditWindow = 24 * time.Hour
+
+var editWindow time.Duration
+
+// InitEditWindow 从环境变量读取消息可以编辑的时长
+// MESSAGE_EDIT_WINDOW 的单位为秒，0 表示不限制
+func InitEditWindow() {
+	seconds := envInt64("MESSAGE_EDIT_WINDOW", int64(defaultEditWindow/time.Second))
+	editWindow = time.Duration(seconds) * time.Second
+}
+
+func GetEditWindow() time.Duration {
+	return editWindow
+}
@@ -1,12 +1,16 @@
 package infra
 
 import (
+	"errors"
 	"log"
 	"os"
 	"sync"
 	"time"
 
+	"github.com/lojes7/inquire/pkg/embedding"
+	"github.com/lojes7/inquire/pkg/scanner"
 	"github.com/lojes7/inquire/pkg/secure"
+	"github.com/lojes7/inquire/pkg/storage"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -23,21 +27,93 @@ func Init() {
 		log.Fatalln(err)
 	}
 
-	InitFileStorage()
+	err = InitStorage()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	InitEmbedder()
+	InitUploadPolicy()
+	InitEditWindow()
+
+	err = InitScanner()
+	if err != nil {
+		log.Fatalln(err)
+	}
 }
 
 var (
-	dbOnce          sync.Once
-	db              *gorm.DB
-	fileStoragePath string
+	dbOnce      sync.Once
+	db          *gorm.DB
+	fileStorage storage.Storage
+	embedder    embedding.Embedder
+	fileScanner scanner.Scanner
 )
 
-func GetFilePath() string {
-	return fileStoragePath
+// InitStorage 初始化文件存储
+// STORAGE_DRIVER 为 s3 时使用 S3 兼容的对象存储，否则保存在 FILE_STORAGE_PATH 目录下
+func InitStorage() error {
+	var err error
+	switch os.Getenv("STORAGE_DRIVER") {
+	case "s3":
+		fileStorage, err = storage.NewS3(storage.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PathStyle: os.Getenv("S3_PATH_STYLE") == "true",
+		})
+	case "", "local":
+		fileStorage, err = storage.NewLocal(os.Getenv("FILE_STORAGE_PATH"))
+	default:
+		err = errors.New("未知的 STORAGE_DRIVER: " + os.Getenv("STORAGE_DRIVER"))
+	}
+	return err
+}
+
+func GetStorage() storage.Storage {
+	return fileStorage
+}
+
+// InitEmbedder 初始化文件语义搜索使用的向量模型
+// 配置了 EMBEDDING_API_URL 时调用 OpenAI 兼容接口，否则使用本地的哈希向量
+func InitEmbedder() {
+	apiURL := os.Getenv("EMBEDDING_API_URL")
+	if apiURL == "" {
+		embedder = embedding.NewHashEmbedder()
+		return
+	}
+	embedder = embedding.NewOpenAIEmbedder(apiURL, os.Getenv("EMBEDDING_API_KEY"), os.Getenv("EMBEDDING_MODEL"))
+}
+
+func GetEmbedder() embedding.Embedder {
+	return embedder
+}
+
+// InitScanner 初始化上传文件的安全扫描
+// SCANNER_DRIVER 为 clamd 时连接 CLAMD_ADDR，为 fake 时使用只识别 EICAR 测试文件的扫描器，不填时不扫描
+func InitScanner() error {
+	switch os.Getenv("SCANNER_DRIVER") {
+	case "clamd":
+		addr := os.Getenv("CLAMD_ADDR")
+		if addr == "" {
+			return errors.New("SCANNER_DRIVER 为 clamd 时需要配置 CLAMD_ADDR")
+		}
+		fileScanner = scanner.NewClamd(addr)
+	case "fake":
+		fileScanner = scanner.NewFake()
+	case "":
+		fileScanner = nil
+	default:
+		return errors.New("未知的 SCANNER_DRIVER: " + os.Getenv("SCANNER_DRIVER"))
+	}
+	return nil
 }
 
-func InitFileStorage() {
-	fileStoragePath = os.Getenv("FILE_STORAGE_PATH")
+// GetScanner 未配置扫描时返回 nil
+func GetScanner() scanner.Scanner {
+	return fileScanner
 }
 
 func InitDatabase() error {
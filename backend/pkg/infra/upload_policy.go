@@ -0,0 +1,57 @@
+package infra
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// UploadPolicy 文件上传限制，大小的单位为字节，0 表示不限制
+type UploadPolicy struct {
+	MaxFileSize       int64
+	UserQuota         int64
+	ConversationQuota int64
+	// 允许和禁止的文件类型，支持 image/* 形式的通配，为空时不限制
+	AllowedTypes []string
+	DeniedTypes  []string
+}
+
+// defaultMaxFileSize 未配置 UPLOAD_MAX_FILE_SIZE 时的单个文件大小上限
+const defaultMaxFileSize = 4 << 30
+
+var uploadPolicy UploadPolicy
+
+// InitUploadPolicy 从环境变量读取上传限制
+func InitUploadPolicy() {
+	uploadPolicy = UploadPolicy{
+		MaxFileSize:       envInt64("UPLOAD_MAX_FILE_SIZE", defaultMaxFileSize),
+		UserQuota:         envInt64("UPLOAD_USER_QUOTA", 0),
+		ConversationQuota: envInt64("UPLOAD_CONVERSATION_QUOTA", 0),
+		AllowedTypes:      envList("UPLOAD_ALLOWED_TYPES"),
+		DeniedTypes:       envList("UPLOAD_DENIED_TYPES"),
+	}
+}
+
+func GetUploadPolicy() UploadPolicy {
+	return uploadPolicy
+}
+
+func envInt64(key string, def int64) int64 {
+	v, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil || v < 0 {
+		return def
+	}
+	return v
+}
+
+// envList 读取以逗号分隔的列表，统一转为小写
+func envList(key string) []string {
+	result := make([]string, 0)
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,170 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"strings"
+
+	// 注册标准库支持的图片格式
+	_ "image/gif"
+	_ "image/png"
+)
+
+// maxImagePixels 生成缩略图允许的最大像素数，避免解码超大图片占用过多内存
+const maxImagePixels = 50_000_000
+
+// Info 从文件中读取的媒体信息，不能确定的字段为 nil
+type Info struct {
+	Width      *int
+	Height     *int
+	PageCount  *int
+	DurationMs *int64
+	// 图片解码后的内容，只有图片才有，用于生成缩略图
+	Image image.Image
+}
+
+// Probe 按文件类型读取媒体信息
+// 图片读取宽高并解码，MP4/MOV 读取时长，PDF 读取页数，其它类型返回空的 Info
+func Probe(r io.ReadSeeker, size int64, contentType string) (*Info, error) {
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return probeImage(r)
+	case contentType == "video/mp4" || contentType == "video/quicktime" || contentType == "audio/mp4":
+		duration, err := MP4Duration(r, size)
+		if err != nil {
+			return nil, err
+		}
+		return &Info{DurationMs: &duration}, nil
+	case contentType == "application/pdf":
+		pages, err := PDFPageCount(r)
+		if err != nil {
+			return nil, err
+		}
+		return &Info{PageCount: &pages}, nil
+	}
+	return &Info{}, nil
+}
+
+func probeImage(r io.ReadSeeker) (*Info, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		// 标准库不支持的图片格式（如 webp、heic）不生成缩略图
+		if errors.Is(err, image.ErrFormat) {
+			return &Info{}, nil
+		}
+		return nil, err
+	}
+	info := &Info{Width: &cfg.Width, Height: &cfg.Height}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return info, nil
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	info.Image = img
+	return info, nil
+}
+
+// Thumbnail 把图片等比缩小到最长边不超过 maxSide，并编码为 JPEG
+// 原图比 maxSide 小时不放大，透明部分填充为白色
+// 直接从解码后的图片取样，不复制原图，同一张图片可以生成多个规格
+func Thumbnail(img image.Image, maxSide int) ([]byte, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return nil, errors.New("图片尺寸为 0")
+	}
+	tw, th := w, h
+	if w > maxSide || h > maxSide {
+		if w >= h {
+			tw, th = maxSide, max(1, h*maxSide/w)
+		} else {
+			tw, th = max(1, w*maxSide/h), maxSide
+		}
+	}
+
+	dst := scale(img, tw, th)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scale 按区域平均缩小图片，每个目标像素取其覆盖的源像素的平均值，再叠加到白色背景上
+func scale(src image.Image, tw, th int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	pixel := pixelReader(src)
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0 := y * h / th
+		y1 := max(y0+1, (y+1)*h/th)
+		for x := 0; x < tw; x++ {
+			x0 := x * w / tw
+			x1 := max(x0+1, (x+1)*w/tw)
+
+			var r, g, bl, a, n uint64
+			for sy := b.Min.Y + y0; sy < b.Min.Y+y1; sy++ {
+				for sx := b.Min.X + x0; sx < b.Min.X+x1; sx++ {
+					pr, pg, pb, pa := pixel(sx, sy)
+					r += uint64(pr)
+					g += uint64(pg)
+					bl += uint64(pb)
+					a += uint64(pa)
+					n++
+				}
+			}
+			// 颜色是预乘过 alpha 的，叠加白色背景只需补上透明的部分
+			white := n*0xff - a
+			off := dst.PixOffset(x, y)
+			dst.Pix[off] = uint8((r + white) / n)
+			dst.Pix[off+1] = uint8((g + white) / n)
+			dst.Pix[off+2] = uint8((bl + white) / n)
+			dst.Pix[off+3] = 0xff
+		}
+	}
+	return dst
+}
+
+// pixelReader 返回读取一个像素的函数，颜色为 8 位并预乘 alpha
+// 常见的解码结果直接读取像素数据，其它类型通过 At 读取
+func pixelReader(src image.Image) func(x, y int) (r, g, b, a uint8) {
+	switch img := src.(type) {
+	case *image.YCbCr:
+		return func(x, y int) (uint8, uint8, uint8, uint8) {
+			yi, ci := img.YOffset(x, y), img.COffset(x, y)
+			r, g, b := color.YCbCrToRGB(img.Y[yi], img.Cb[ci], img.Cr[ci])
+			return r, g, b, 0xff
+		}
+	case *image.RGBA:
+		return func(x, y int) (uint8, uint8, uint8, uint8) {
+			p := img.Pix[img.PixOffset(x, y):]
+			return p[0], p[1], p[2], p[3]
+		}
+	case *image.NRGBA:
+		return func(x, y int) (uint8, uint8, uint8, uint8) {
+			p := img.Pix[img.PixOffset(x, y):]
+			a := uint16(p[3])
+			return uint8(uint16(p[0]) * a / 0xff), uint8(uint16(p[1]) * a / 0xff), uint8(uint16(p[2]) * a / 0xff), p[3]
+		}
+	case *image.Gray:
+		return func(x, y int) (uint8, uint8, uint8, uint8) {
+			v := img.Pix[img.PixOffset(x, y)]
+			return v, v, v, 0xff
+		}
+	}
+	return func(x, y int) (uint8, uint8, uint8, uint8) {
+		r, g, b, a := src.At(x, y).RGBA()
+		return uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)
+	}
+}
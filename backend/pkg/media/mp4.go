@@ -0,0 +1,86 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// MP4Duration 从 MP4/MOV 的 moov/mvhd 中读取时长，单位毫秒
+// 只读取 box 头并跳过其它内容，不需要读取整个文件
+func MP4Duration(r io.ReadSeeker, size int64) (int64, error) {
+	moovStart, moovSize, err := findBox(r, 0, size, "moov")
+	if err != nil {
+		return 0, err
+	}
+	mvhdStart, _, err := findBox(r, moovStart, moovStart+moovSize, "mvhd")
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := r.Seek(mvhdStart, io.SeekStart); err != nil {
+		return 0, err
+	}
+	var version [4]byte
+	if _, err := io.ReadFull(r, version[:]); err != nil {
+		return 0, err
+	}
+
+	var timescale uint32
+	var duration uint64
+	if version[0] == 1 {
+		var buf [28]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return 0, err
+		}
+		timescale = binary.BigEndian.Uint32(buf[16:20])
+		duration = binary.BigEndian.Uint64(buf[20:28])
+	} else {
+		var buf [16]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return 0, err
+		}
+		timescale = binary.BigEndian.Uint32(buf[8:12])
+		duration = uint64(binary.BigEndian.Uint32(buf[12:16]))
+	}
+	if timescale == 0 {
+		return 0, errors.New("mvhd 中的 timescale 为 0")
+	}
+	return int64(duration * 1000 / uint64(timescale)), nil
+}
+
+// findBox 在 [start, end) 范围内查找指定类型的 box，返回内容的起始位置和长度
+func findBox(r io.ReadSeeker, start, end int64, boxType string) (int64, int64, error) {
+	pos := start
+	for pos+8 <= end {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return 0, 0, err
+		}
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return 0, 0, err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		switch boxSize {
+		case 0:
+			// 一直延伸到文件末尾
+			boxSize = end - pos
+		case 1:
+			var large [8]byte
+			if _, err := io.ReadFull(r, large[:]); err != nil {
+				return 0, 0, err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(large[:]))
+			headerSize = 16
+		}
+		if boxSize < headerSize {
+			return 0, 0, errors.New("box 长度错误")
+		}
+		if string(header[4:8]) == boxType {
+			return pos + headerSize, boxSize - headerSize, nil
+		}
+		pos += boxSize
+	}
+	return 0, 0, errors.New("没有找到 " + boxType)
+}
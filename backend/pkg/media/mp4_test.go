@@ -0,0 +1,104 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// box 生成一个 32 位长度的 box
+func box(boxType string, payload ...[]byte) []byte {
+	content := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(content)))
+	b = append(b, boxType...)
+	return append(b, content...)
+}
+
+// largeBox 生成一个 64 位长度的 box
+func largeBox(boxType string, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, 1)
+	b = append(b, boxType...)
+	b = binary.BigEndian.AppendUint64(b, uint64(16+len(payload)))
+	return append(b, payload...)
+}
+
+// mvhd0 版本 0 的 mvhd，时间和时长为 32 位
+func mvhd0(timescale, duration uint32) []byte {
+	p := make([]byte, 4+8)
+	p = binary.BigEndian.AppendUint32(p, timescale)
+	p = binary.BigEndian.AppendUint32(p, duration)
+	return box("mvhd", p, make([]byte, 80))
+}
+
+// mvhd1 版本 1 的 mvhd，时间和时长为 64 位
+func mvhd1(timescale uint32, duration uint64) []byte {
+	p := []byte{1, 0, 0, 0}
+	p = append(p, make([]byte, 16)...)
+	p = binary.BigEndian.AppendUint32(p, timescale)
+	p = binary.BigEndian.AppendUint64(p, duration)
+	return box("mvhd", p, make([]byte, 80))
+}
+
+func TestMP4Duration(t *testing.T) {
+	ftyp := box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41"))
+	tests := []struct {
+		name string
+		data []byte
+		want int64
+	}{
+		{"v0", bytes.Join([][]byte{ftyp, box("moov", mvhd0(1000, 5500))}, nil), 5500},
+		{"v0 timescale", bytes.Join([][]byte{ftyp, box("moov", mvhd0(600, 1800))}, nil), 3000},
+		// 30 小时，时长超过 32 位
+		{"v1", bytes.Join([][]byte{ftyp, box("moov", mvhd1(90000, 90000*3600*30))}, nil), 30 * 3600 * 1000},
+		{
+			"moov after large mdat",
+			bytes.Join([][]byte{ftyp, largeBox("mdat", make([]byte, 1000)), box("moov", mvhd0(1000, 42))}, nil),
+			42,
+		},
+		{
+			"mvhd after other boxes",
+			bytes.Join([][]byte{ftyp, box("moov", box("udta", make([]byte, 20)), mvhd0(1000, 7))}, nil),
+			7,
+		},
+		{
+			"mdat to end of file",
+			bytes.Join([][]byte{ftyp, box("moov", mvhd0(1000, 9)), {0, 0, 0, 0}, []byte("mdat"), make([]byte, 100)}, nil),
+			9,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MP4Duration(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("MP4Duration = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMP4DurationErrors(t *testing.T) {
+	ftyp := box("ftyp", []byte("isom"))
+	truncated := bytes.Join([][]byte{ftyp, box("moov", mvhd0(1000, 5500))}, nil)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"no moov", bytes.Join([][]byte{ftyp, box("mdat", make([]byte, 10))}, nil)},
+		{"no mvhd", bytes.Join([][]byte{ftyp, box("moov", box("trak"))}, nil)},
+		{"zero timescale", bytes.Join([][]byte{ftyp, box("moov", mvhd0(0, 5500))}, nil)},
+		{"bad box size", bytes.Join([][]byte{ftyp, {0, 0, 0, 4}, []byte("free")}, nil)},
+		{"truncated mvhd", truncated[:len(ftyp)+8+8+10]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MP4Duration(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err == nil {
+				t.Errorf("MP4Duration = %d, want error", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package media
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// maxPDFScanBytes 统计页数时最多读取的字节数
+const maxPDFScanBytes = 32 << 20
+
+var (
+	pdfPagesCount = regexp.MustCompile(`/Type\s*/Pages\b[^>]*?/Count\s+(\d+)|/Count\s+(\d+)[^>]*?/Type\s*/Pages\b`)
+	pdfPage       = regexp.MustCompile(`/Type\s*/Page[^s]`)
+)
+
+// PDFPageCount 统计 PDF 的页数
+// 优先取页面树根节点的 /Count，找不到时统计 /Type /Page 对象的个数
+// 页面对象放在压缩对象流中的 PDF 可能统计不到，此时返回错误
+func PDFPageCount(r io.Reader) (int, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxPDFScanBytes))
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range pdfPagesCount.FindAllSubmatch(data, -1) {
+		digits := m[1]
+		if len(digits) == 0 {
+			digits = m[2]
+		}
+		n, err := strconv.Atoi(string(digits))
+		if err == nil && n > count {
+			count = n
+		}
+	}
+	if count == 0 {
+		count = len(pdfPage.FindAllIndex(data, -1))
+	}
+	if count == 0 {
+		return 0, errors.New("无法确定 PDF 页数")
+	}
+	return count, nil
+}
@@ -0,0 +1,69 @@
+package media
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPDFPageCount(t *testing.T) {
+	tests := []struct {
+		name string
+		pdf  string
+		want int
+	}{
+		{
+			"pages root",
+			"%PDF-1.4\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n" +
+				"2 0 obj\n<< /Type /Pages /Kids [3 0 R 4 0 R 5 0 R] /Count 3 >>\nendobj\n" +
+				"3 0 obj\n<< /Type /Page /Parent 2 0 R >>\nendobj\n",
+			3,
+		},
+		{
+			"count before type",
+			"2 0 obj\n<</Count 12/Kids [3 0 R]/Type/Pages>>\nendobj\n",
+			12,
+		},
+		{
+			// 中间节点的 /Count 比根节点小，取最大的
+			"nested page tree",
+			"2 0 obj\n<< /Type /Pages /Kids [6 0 R 7 0 R] /Count 5 >>\nendobj\n" +
+				"6 0 obj\n<< /Type /Pages /Parent 2 0 R /Count 2 >>\nendobj\n" +
+				"7 0 obj\n<< /Type /Pages /Parent 2 0 R /Count 3 >>\nendobj\n",
+			5,
+		},
+		{
+			// 没有页面树的 /Count 时统计页面对象，/Type /Pages 不算
+			"count page objects",
+			"2 0 obj\n<< /Type /Pages /Kids [3 0 R 4 0 R] >>\nendobj\n" +
+				"3 0 obj\n<< /Type /Page /Parent 2 0 R >>\nendobj\n" +
+				"4 0 obj\n<</Type/Page/Parent 2 0 R>>\nendobj\n",
+			2,
+		},
+		{
+			// 其它对象的 /Count（如书签）不是页数
+			"outline count",
+			"1 0 obj\n<< /Type /Outlines /Count 7 >>\nendobj\n" +
+				"3 0 obj\n<< /Type /Page >>\nendobj\n",
+			1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PDFPageCount(strings.NewReader(tt.pdf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("PDFPageCount = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPDFPageCountError(t *testing.T) {
+	// 页面对象都在压缩的对象流中时统计不到
+	pdf := "%PDF-1.5\n5 0 obj\n<< /Type /ObjStm /N 3 /Filter /FlateDecode >>\nstream\nx\x9c\nendstream\nendobj\n"
+	if got, err := PDFPageCount(strings.NewReader(pdf)); err == nil {
+		t.Errorf("PDFPageCount = %d, want error", got)
+	}
+}
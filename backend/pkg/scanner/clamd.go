@@ -0,0 +1,107 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize INSTREAM 每次发送的数据块大小
+const clamdChunkSize = 64 << 10
+
+// clamdTimeout 未设置 ctx 截止时间时的单次扫描超时
+const clamdTimeout = 10 * time.Minute
+
+// Clamd 通过 clamd 的 TCP 协议扫描文件
+// 使用 INSTREAM 命令把内容发送给 clamd，不要求 clamd 能访问文件存储
+type Clamd struct {
+	addr   string
+	dialer net.Dialer
+}
+
+// NewClamd addr 为 clamd 的地址，如 clamav:3310
+func NewClamd(addr string) *Clamd {
+	return &Clamd{
+		addr:   addr,
+		dialer: net.Dialer{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	conn, err := c.dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(clamdTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	// z 前缀表示命令和回复都以 \0 结尾
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, err
+	}
+
+	// 数据块格式：4 字节大端长度 + 内容，长度为 0 的块表示结束
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, readErr := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				// 超过 StreamMaxLength 时 clamd 会先回复错误再关闭连接
+				if reply, replyErr := readClamdReply(conn); replyErr == nil {
+					return parseClamdReply(reply)
+				}
+				return nil, err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return nil, err
+	}
+
+	reply, err := readClamdReply(conn)
+	if err != nil {
+		return nil, err
+	}
+	return parseClamdReply(reply)
+}
+
+func readClamdReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(errors.Is(err, io.EOF) && reply != "") {
+		return "", err
+	}
+	return strings.TrimRight(reply, "\x00\n"), nil
+}
+
+// parseClamdReply 解析 INSTREAM 的回复
+// 形如 "stream: OK"、"stream: Eicar-Signature FOUND" 或 "INSTREAM size limit exceeded. ERROR"
+func parseClamdReply(reply string) (*Result, error) {
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return &Result{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return &Result{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	}
+	return nil, fmt.Errorf("clamd: %s", reply)
+}
@@ -0,0 +1,139 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseClamdReply(t *testing.T) {
+	tests := []struct {
+		reply     string
+		want      *Result
+		wantError bool
+	}{
+		{reply: "stream: OK", want: &Result{}},
+		{reply: "stream: Eicar-Signature FOUND", want: &Result{Infected: true, Signature: "Eicar-Signature"}},
+		{reply: "stream: Win.Test.EICAR_HDB-1 FOUND", want: &Result{Infected: true, Signature: "Win.Test.EICAR_HDB-1"}},
+		{reply: "INSTREAM size limit exceeded. ERROR", wantError: true},
+		{reply: "stream: Can't allocate memory ERROR", wantError: true},
+		{reply: "", wantError: true},
+	}
+	for _, tt := range tests {
+		got, err := parseClamdReply(tt.reply)
+		if tt.wantError {
+			if err == nil {
+				t.Errorf("parseClamdReply(%q) = %+v, want error", tt.reply, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseClamdReply(%q) error: %v", tt.reply, err)
+			continue
+		}
+		if *got != *tt.want {
+			t.Errorf("parseClamdReply(%q) = %+v, want %+v", tt.reply, got, tt.want)
+		}
+	}
+}
+
+// startClamd 启动一个模拟的 clamd，读取 INSTREAM 的全部数据块后用 reply 回复
+// 收到的内容通过返回的 channel 传出
+func startClamd(t *testing.T, reply func(content []byte) string) (string, <-chan []byte) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		cmd, err := r.ReadString(0)
+		if err != nil || cmd != "zINSTREAM\x00" {
+			t.Errorf("command = %q, %v", cmd, err)
+			return
+		}
+		var content bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				t.Error(err)
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if _, err := io.CopyN(&content, r, int64(size)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		received <- content.Bytes()
+		conn.Write([]byte(reply(content.Bytes()) + "\x00"))
+	}()
+	return ln.Addr().String(), received
+}
+
+func TestClamdScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		reply   string
+		want    *Result
+	}{
+		{"clean", "hello", "stream: OK", &Result{}},
+		{"infected", "EICAR", "stream: Eicar-Signature FOUND", &Result{Infected: true, Signature: "Eicar-Signature"}},
+		{"empty", "", "stream: OK", &Result{}},
+		// 超过一个数据块的内容分多块发送
+		{"chunks", strings.Repeat("x", clamdChunkSize*2+10), "stream: OK", &Result{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, received := startClamd(t, func([]byte) string { return tt.reply })
+			got, err := NewClamd(addr).Scan(t.Context(), strings.NewReader(tt.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != *tt.want {
+				t.Errorf("Scan = %+v, want %+v", got, tt.want)
+			}
+			if content := <-received; string(content) != tt.content {
+				t.Errorf("clamd received %d bytes, want %d", len(content), len(tt.content))
+			}
+		})
+	}
+}
+
+func TestClamdScanError(t *testing.T) {
+	addr, _ := startClamd(t, func([]byte) string { return "INSTREAM size limit exceeded. ERROR" })
+	_, err := NewClamd(addr).Scan(t.Context(), strings.NewReader("hello"))
+	if err == nil || !strings.Contains(err.Error(), "size limit exceeded") {
+		t.Errorf("Scan error = %v", err)
+	}
+
+	// clamd 不可用
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := ln.Addr().String()
+	ln.Close()
+	_, err = NewClamd(closedAddr).Scan(t.Context(), strings.NewReader("hello"))
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Errorf("Scan on closed port error = %v, want *net.OpError", err)
+	}
+}
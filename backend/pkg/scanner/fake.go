@@ -0,0 +1,39 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// EICAR 标准的杀毒软件测试字符串，所有杀毒软件都会把它识别为病毒
+const EICAR = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// Fake 不依赖外部服务的扫描器，用于开发和测试
+// 内容中包含 EICAR 测试字符串或任意一个 Patterns 时视为感染
+type Fake struct {
+	Patterns map[string][]byte
+	// Err 不为空时每次扫描都返回该错误，用于模拟扫描服务不可用
+	Err error
+}
+
+func NewFake() *Fake {
+	return &Fake{Patterns: map[string][]byte{"Eicar-Signature": []byte(EICAR)}}
+}
+
+func (f *Fake) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	for name, pattern := range f.Patterns {
+		if bytes.Contains(data, pattern) {
+			return &Result{Infected: true, Signature: name}, nil
+		}
+	}
+	return &Result{}, nil
+}
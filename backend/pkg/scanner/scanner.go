@@ -0,0 +1,19 @@
+package scanner
+
+import (
+	"context"
+	"io"
+)
+
+// Result 一次扫描的结果
+type Result struct {
+	Infected bool
+	// 命中的病毒或规则名称，未感染时为空
+	Signature string
+}
+
+// Scanner 检查文件内容是否含有恶意代码
+// 扫描失败返回 error，此时不能认为文件是安全的
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (*Result, error)
+}
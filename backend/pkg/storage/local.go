@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local 本地磁盘存储，对象保存为 root 目录下的文件
+// 多个后端实例需要共享同一个目录（如挂载同一个卷）才能互相访问文件
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) (*Local, error) {
+	if root == "" {
+		return nil, errors.New("未配置文件保存路径")
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &Local{root: root}, nil
+}
+
+// path 把 key 转换为 root 下的文件路径，拒绝跳出 root 的 key
+func (l *Local) path(key string) (string, error) {
+	if key == "" || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || cleaned != "/"+key {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(l.root, filepath.FromSlash(cleaned[1:])), nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	// 先写临时文件再重命名，避免读到写了一半的文件
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, l.info(key, fi), nil
+}
+
+func (l *Local) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(file, length), Closer: file}, nil
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return l.info(key, fi), nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Presign 本地存储没有独立的下载服务，文件只能通过后端接口下载
+func (l *Local) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+func (l *Local) info(key string, fi os.FileInfo) *ObjectInfo {
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		ContentType:  contentType,
+		LastModified: fi.ModTime(),
+	}
+}
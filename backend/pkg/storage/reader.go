@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ReadSeeker 在 Storage 之上实现 io.ReadSeekCloser，用于 http.ServeContent
+// Seek 不会发起请求，下一次 Read 时才从新的位置读取
+type ReadSeeker struct {
+	ctx    context.Context
+	s      Storage
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+// NewReadSeeker size 为对象的大小，通常来自 Stat 或数据库中的记录
+func NewReadSeeker(ctx context.Context, s Storage, key string, size int64) *ReadSeeker {
+	return &ReadSeeker{ctx: ctx, s: s, key: key, size: size}
+}
+
+func (r *ReadSeeker) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.s.GetRange(r.ctx, r.key, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *ReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, errors.New("无效的 whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("无效的偏移量")
+	}
+	if abs != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = abs
+	return abs, nil
+}
+
+func (r *ReadSeeker) Close() error {
+	if r.body != nil {
+		return r.body.Close()
+	}
+	return nil
+}
+
+// limitedReadCloser 限制读取长度的同时保留底层的 Close
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// countingStorage 记录 GetRange 的调用，用于确认 Seek 不发起请求
+type countingStorage struct {
+	Storage
+	ranges []int64
+}
+
+func (s *countingStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	s.ranges = append(s.ranges, offset)
+	return s.Storage.GetRange(ctx, key, offset, length)
+}
+
+func newTestReadSeeker(t *testing.T, content string) (*ReadSeeker, *countingStorage) {
+	t.Helper()
+	local, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = local.Put(t.Context(), "a/b.txt", strings.NewReader(content), int64(len(content)), "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &countingStorage{Storage: local}
+	r := NewReadSeeker(t.Context(), s, "a/b.txt", int64(len(content)))
+	t.Cleanup(func() { r.Close() })
+	return r, s
+}
+
+func TestReadSeekerSeek(t *testing.T) {
+	const content = "0123456789abcdef"
+	tests := []struct {
+		name    string
+		offset  int64
+		whence  int
+		wantPos int64
+		want    string
+	}{
+		{"start", 3, io.SeekStart, 3, "3456789abcdef"},
+		{"current", 2, io.SeekCurrent, 6, "6789abcdef"},
+		{"end", -4, io.SeekEnd, 12, "cdef"},
+		{"at end", 0, io.SeekEnd, 16, ""},
+		{"past end", 5, io.SeekEnd, 21, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newTestReadSeeker(t, content)
+			// SeekCurrent 相对于先读取的 4 个字节
+			if _, err := io.ReadFull(r, make([]byte, 4)); err != nil {
+				t.Fatal(err)
+			}
+			pos, err := r.Seek(tt.offset, tt.whence)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pos != tt.wantPos {
+				t.Errorf("Seek = %d, want %d", pos, tt.wantPos)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("read after Seek = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadSeekerInvalidSeek(t *testing.T) {
+	r, _ := newTestReadSeeker(t, "0123456789")
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek(-1, SeekStart) should fail")
+	}
+	if _, err := r.Seek(-11, io.SeekEnd); err == nil {
+		t.Error("Seek(-11, SeekEnd) should fail")
+	}
+	if _, err := r.Seek(0, 3); err == nil {
+		t.Error("Seek with invalid whence should fail")
+	}
+}
+
+// TestReadSeekerRequests 只有位置改变后的 Read 才重新请求，Seek 到当前位置时复用原来的内容
+func TestReadSeekerRequests(t *testing.T) {
+	r, s := newTestReadSeeker(t, "0123456789")
+
+	if _, err := r.Seek(5, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.ranges) != 0 {
+		t.Fatalf("Seek sent %d requests", len(s.ranges))
+	}
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "56" {
+		t.Fatalf("Read = %q, %v", buf, err)
+	}
+	if _, err := r.Seek(0, io.SeekCurrent); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "78" {
+		t.Fatalf("Read = %q, %v", buf, err)
+	}
+	if _, err := r.Seek(1, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "12" {
+		t.Fatalf("Read = %q, %v", buf, err)
+	}
+
+	want := []int64{5, 1}
+	if !slices.Equal(s.ranges, want) {
+		t.Errorf("GetRange offsets = %v, want %v", s.ranges, want)
+	}
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config S3 兼容存储的配置
+type S3Config struct {
+	// Endpoint 服务地址，如 https://s3.us-east-1.amazonaws.com 或 http://minio:9000
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle 为 true 时使用 endpoint/bucket/key 形式的地址，MinIO 等自建服务通常需要开启
+	PathStyle bool
+}
+
+// S3 兼容 AWS S3 协议的对象存储，如 AWS S3、MinIO、Cloudflare R2
+type S3 struct {
+	endpoint  *url.URL
+	bucket    string
+	pathStyle bool
+	signer    *signer
+	client    *http.Client
+}
+
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("S3 配置不完整")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("S3 endpoint 格式错误: %s", cfg.Endpoint)
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3{
+		endpoint:  endpoint,
+		bucket:    cfg.Bucket,
+		pathStyle: cfg.PathStyle,
+		signer: &signer{
+			accessKey: cfg.AccessKey,
+			secretKey: cfg.SecretKey,
+			region:    region,
+			service:   "s3",
+		},
+		client: &http.Client{},
+	}, nil
+}
+
+// objectURL 返回对象的地址
+func (s *S3) objectURL(key string) (*url.URL, error) {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return nil, ErrInvalidKey
+	}
+	u := *s.endpoint
+	prefix := u.Path
+	if s.pathStyle {
+		prefix += "/" + s.bucket
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path = prefix + "/" + key
+	u.RawPath = uriEncode(prefix, false) + "/" + uriEncode(key, false)
+	return &u, nil
+}
+
+func (s *S3) do(ctx context.Context, method, key string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	// ContentLength 为 0 而 Body 不为空时会按 chunked 发送，S3 不支持，空内容改用 NoBody
+	if body != nil && size == 0 {
+		body = http.NoBody
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		req.ContentLength = size
+		payloadHash = unsignedPayload
+	}
+	s.signer.sign(req, payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, parseS3Error(resp)
+	}
+	return resp, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, r, size, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, objectInfo(key, resp), nil
+}
+
+func (s *S3) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	if length < 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else if length > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0, header)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return objectInfo(key, resp), nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0, nil)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Presign 生成 GET 预签名地址，S3 允许的最长有效期为 7 天
+func (s *S3) Presign(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if expires < time.Second || expires > 7*24*time.Hour {
+		return "", errors.New("预签名有效期必须在 1 秒到 7 天之间")
+	}
+	u, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+	return s.signer.presign(http.MethodGet, u, expires, time.Now()), nil
+}
+
+func objectInfo(key string, resp *http.Response) *ObjectInfo {
+	info := &ObjectInfo{
+		Key:         key,
+		ContentType: resp.Header.Get("Content-Type"),
+		ETag:        resp.Header.Get("ETag"),
+	}
+	info.Size, _ = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	info.LastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info
+}
+
+// s3Error S3 返回的错误信息
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func parseS3Error(resp *http.Response) error {
+	var e s3Error
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err := xml.Unmarshal(body, &e); err != nil || e.Code == "" {
+		return fmt.Errorf("S3 请求失败: %s", resp.Status)
+	}
+	return fmt.Errorf("S3 请求失败: %s %s: %s", resp.Status, e.Code, e.Message)
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestS3 创建连接到本地测试服务的 S3，使用 path style 地址
+func newTestS3(t *testing.T, handler http.HandlerFunc) *S3 {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	s, err := NewS3(S3Config{
+		Endpoint:  server.URL,
+		Bucket:    "bucket",
+		AccessKey: "ak",
+		SecretKey: "sk",
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestObjectURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoint  string
+		pathStyle bool
+		key       string
+		want      string
+	}{
+		{"virtual-host", "https://s3.us-east-1.amazonaws.com", false, "ab/cd.txt", "https://bucket.s3.us-east-1.amazonaws.com/ab/cd.txt"},
+		{"path-style", "http://minio:9000", true, "ab/cd.txt", "http://minio:9000/bucket/ab/cd.txt"},
+		{"escape", "http://minio:9000", true, "a b+c.txt", "http://minio:9000/bucket/a%20b%2Bc.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewS3(S3Config{
+				Endpoint:  tt.endpoint,
+				Bucket:    "bucket",
+				AccessKey: "ak",
+				SecretKey: "sk",
+				PathStyle: tt.pathStyle,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := s.objectURL(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := u.String(); got != tt.want {
+				t.Errorf("objectURL = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	s, err := NewS3(S3Config{Endpoint: "http://minio:9000", Bucket: "b", AccessKey: "ak", SecretKey: "sk"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"", "/abs"} {
+		if _, err := s.objectURL(key); err != ErrInvalidKey {
+			t.Errorf("objectURL(%q) err = %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+// TestPutEmpty 上传空内容时必须带 Content-Length: 0，不能按 chunked 发送
+func TestPutEmpty(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"empty", ""},
+		{"content", "hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotLength int64
+			var gotEncoding []string
+			var gotBody string
+			s := newTestS3(t, func(w http.ResponseWriter, r *http.Request) {
+				gotLength = r.ContentLength
+				gotEncoding = r.TransferEncoding
+				data, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Error(err)
+				}
+				gotBody = string(data)
+			})
+
+			// 用不能推断长度的 Reader，模拟从上传的分片或临时文件读取
+			body := struct{ *strings.Reader }{strings.NewReader(tt.body)}
+			err := s.Put(t.Context(), "a/b.txt", body, int64(len(tt.body)), "text/plain")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotLength != int64(len(tt.body)) || len(gotEncoding) != 0 {
+				t.Errorf("Content-Length = %d, Transfer-Encoding = %v, want %d and none",
+					gotLength, gotEncoding, len(tt.body))
+			}
+			if gotBody != tt.body {
+				t.Errorf("body = %q, want %q", gotBody, tt.body)
+			}
+		})
+	}
+}
+
+func TestS3Errors(t *testing.T) {
+	s := newTestS3(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bucket/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/bucket/denied":
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	if _, _, err := s.Get(t.Context(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get missing err = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(t.Context(), "missing"); err != nil {
+		t.Errorf("Delete missing err = %v, want nil", err)
+	}
+	_, _, err := s.Get(t.Context(), "denied")
+	if err == nil || !strings.Contains(err.Error(), "AccessDenied: Access Denied") {
+		t.Errorf("Get denied err = %v", err)
+	}
+	if _, err := s.Stat(t.Context(), "other"); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("Stat err = %v", err)
+	}
+}
+
+func TestS3GetRange(t *testing.T) {
+	content := "0123456789"
+	var gotRange string
+	s := newTestS3(t, func(w http.ResponseWriter, r *http.Request) {
+		gotRange = r.Header.Get("Range")
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	})
+
+	tests := []struct {
+		offset, length int64
+		wantRange      string
+		want           string
+	}{
+		{2, 3, "bytes=2-4", "234"},
+		{7, -1, "bytes=7-", "789"},
+	}
+	for _, tt := range tests {
+		body, err := s.GetRange(t.Context(), "a.txt", tt.offset, tt.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotRange != tt.wantRange || string(got) != tt.want {
+			t.Errorf("GetRange(%d, %d) = %q with Range %q, want %q with %q",
+				tt.offset, tt.length, got, gotRange, tt.want, tt.wantRange)
+		}
+	}
+
+	// 长度为 0 时不发请求
+	gotRange = "unchanged"
+	body, err := s.GetRange(t.Context(), "a.txt", 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(body); len(got) != 0 || gotRange != "unchanged" {
+		t.Errorf("GetRange length 0 = %q, request sent: %v", got, gotRange != "unchanged")
+	}
+}
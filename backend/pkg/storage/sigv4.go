@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm   = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat  = "20060102T150405Z"
+	sigV4DateFormat  = "20060102"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// signer 实现 AWS Signature Version 4，只覆盖 S3 需要的部分
+type signer struct {
+	accessKey string
+	secretKey string
+	region    string
+	service   string
+}
+
+// sign 为请求添加 Authorization 头
+// payloadHash 为请求体的 sha256，不校验请求体时传 UNSIGNED-PAYLOAD
+func (s *signer) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(sigV4TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders, canonicalHeaders := canonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := s.scope(now)
+	signature := s.signature(now, amzDate, scope, canonicalRequest)
+	req.Header.Set("Authorization", sigV4Algorithm+
+		" Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+signature)
+}
+
+// presign 生成预签名地址，只签名 host 头
+func (s *signer) presign(method string, u *url.URL, expires time.Duration, now time.Time) string {
+	amzDate := now.UTC().Format(sigV4TimeFormat)
+	scope := s.scope(now)
+
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", sigV4Algorithm)
+	query.Set("X-Amz-Credential", s.accessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		canonicalURI(u),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	query.Set("X-Amz-Signature", s.signature(now, amzDate, scope, canonicalRequest))
+	signed := *u
+	signed.RawQuery = canonicalQuery(query)
+	return signed.String()
+}
+
+func (s *signer) scope(now time.Time) string {
+	return now.UTC().Format(sigV4DateFormat) + "/" + s.region + "/" + s.service + "/aws4_request"
+}
+
+func (s *signer) signature(now time.Time, amzDate, scope, canonicalRequest string) string {
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.UTC().Format(sigV4DateFormat))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalHeaders 返回参与签名的头名称列表和规范化后的头
+// 签名 host、content-type、content-md5 以及所有 x-amz- 开头的头
+func canonicalHeaders(req *http.Request) (string, string) {
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || lower == "content-md5" || strings.HasPrefix(lower, "x-amz-") {
+			trimmed := make([]string, len(values))
+			for i, v := range values {
+				trimmed[i] = strings.Join(strings.Fields(v), " ")
+			}
+			headers[lower] = strings.Join(trimmed, ",")
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(name + ":" + headers[name] + "\n")
+	}
+	return strings.Join(names, ";"), sb.String()
+}
+
+// canonicalURI S3 的路径只编码一次，保留 /
+// 调用方需要用 uriEncode 设置 RawPath，保证与 AWS 的编码规则一致
+func canonicalURI(u *url.URL) string {
+	p := u.EscapedPath()
+	if p == "" {
+		return "/"
+	}
+	return p
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode 按 RFC 3986 编码，只保留非保留字符，encodeSlash 为 false 时保留 /
+func uriEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			sb.WriteByte(c)
+			continue
+		}
+		sb.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+	}
+	return sb.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
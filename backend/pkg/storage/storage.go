@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	// ErrNotFound 对象不存在
+	ErrNotFound = errors.New("文件不存在")
+	// ErrPresignNotSupported 存储后端不支持生成预签名地址
+	ErrPresignNotSupported = errors.New("存储后端不支持预签名地址")
+	// ErrInvalidKey key 为空或包含非法路径
+	ErrInvalidKey = errors.New("非法的文件key")
+)
+
+// ObjectInfo 对象的元信息
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+	ETag         string
+}
+
+// Storage 文件存储后端
+// key 为以 / 分隔的相对路径，由调用方生成，数据库中只保存 key
+type Storage interface {
+	// Put 写入对象，size 为内容长度，已存在的对象会被覆盖
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get 读取对象，调用方负责关闭返回的 ReadCloser
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// GetRange 从 offset 开始读取 length 字节，length 小于 0 时读到末尾
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Stat 获取对象的元信息
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// Delete 删除对象，对象不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// Presign 生成在 expires 内有效、无需鉴权即可下载的地址
+	Presign(ctx context.Context, key string, expires time.Duration) (string, error)
+}
@@ -98,3 +98,16 @@ func NewUniqueID() uint64 {
 
 	return r
 }
+
+// TimeFromID 从雪花ID中取出生成它的时间（毫秒精度）
+func TimeFromID(id uint64) time.Time {
+	ms := int64(id>>(nodeBits+stepBits)) + epoch
+	return time.UnixMilli(ms)
+}
+
+// MaxIDAt 返回在某一毫秒内可能生成的最大雪花ID
+// 雪花ID的高位是时间，可以用它按时间划分ID的范围
+func MaxIDAt(t time.Time) uint64 {
+	ms := t.UnixMilli() - epoch
+	return uint64(ms<<(nodeBits+stepBits)) | (1<<(nodeBits+stepBits) - 1)
+}